@@ -0,0 +1,161 @@
+// Package auth models who is calling the API and what they may do.
+// Credentials are resolved into a Principal by an Authenticator; handlers
+// then check whether the principal may act on the keys they touch, either
+// through an app-wide permission or through the policies of its roles.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/keanutaufan/kvstored/api/repository"
+)
+
+// Permission levels are ordered: each level includes the ones below it.
+type Permission int
+
+const (
+	None Permission = iota
+	Read
+	Write
+	Admin
+)
+
+// AllApps scopes a permission to every app.
+const AllApps = "*"
+
+// Anonymous is the subject of every caller when authentication is
+// disabled.
+const Anonymous = "anonymous"
+
+var permissionNames = map[Permission]string{
+	None:  "none",
+	Read:  "read",
+	Write: "write",
+	Admin: "admin",
+}
+
+func (p Permission) String() string {
+	if name, ok := permissionNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("Permission(%d)", int(p))
+}
+
+func ParsePermission(name string) (Permission, error) {
+	for permission, permissionName := range permissionNames {
+		if permission != None && permissionName == strings.ToLower(name) {
+			return permission, nil
+		}
+	}
+	return None, repository.NewError(repository.ErrValidation, fmt.Sprintf("unknown permission %q, must be read, write or admin", name))
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Subject  string
+	Scopes   map[string]Permission // app ID or AllApps -> permission
+	Roles    []string
+	Policies []Policy // resolved from Roles by WithRoles
+}
+
+// Permission returns the caller's permission on appID. A nil principal has
+// no permissions.
+func (p *Principal) Permission(appID string) Permission {
+	if p == nil {
+		return None
+	}
+	return max(p.Scopes[appID], p.Scopes[AllApps])
+}
+
+// CanGrant reports whether the caller administers every app in scopes, and
+// may therefore hand out or take away those permissions.
+func (p *Principal) CanGrant(scopes map[string]Permission) bool {
+	for appID := range scopes {
+		if p.Permission(appID) < Admin {
+			return false
+		}
+	}
+	return true
+}
+
+type Authenticator interface {
+	// Authenticate resolves a credential, which is empty when the caller
+	// sent none, into a principal.
+	Authenticate(ctx context.Context, credential string) (*Principal, error)
+}
+
+type allowAll struct{}
+
+// AllowAll returns an Authenticator that grants every caller admin
+// permission on all apps, for deployments that run without authentication.
+func AllowAll() Authenticator {
+	return allowAll{}
+}
+
+func (allowAll) Authenticate(ctx context.Context, credential string) (*Principal, error) {
+	return &Principal{Subject: Anonymous, Scopes: map[string]Permission{AllApps: Admin}}, nil
+}
+
+type chain []Authenticator
+
+// Chain returns an Authenticator that accepts a credential if any of
+// authenticators does. When all of them reject it, the most specific
+// rejection is returned; errors other than authentication failures, such as
+// an unavailable database, are returned immediately.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return chain(authenticators)
+}
+
+func (c chain) Authenticate(ctx context.Context, credential string) (*Principal, error) {
+	var rejection error
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(ctx, credential)
+		if err == nil {
+			return principal, nil
+		}
+		if !errors.Is(err, ErrUnauthenticated) {
+			return nil, err
+		}
+		if rejection == nil || (errors.Is(rejection, ErrInvalidCredential) && !errors.Is(err, ErrInvalidCredential)) {
+			rejection = err
+		}
+	}
+	if rejection == nil {
+		rejection = fmt.Errorf("%w: no authenticators configured", ErrUnauthenticated)
+	}
+	return nil, rejection
+}
+
+// Credential extracts the credential from an "Authorization: Bearer" or
+// X-API-Key header.
+func Credential(header http.Header) string {
+	if authorization := header.Get("Authorization"); authorization != "" {
+		scheme, credential, found := strings.Cut(authorization, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(credential)
+		}
+	}
+	return header.Get("X-API-Key")
+}
+
+type principalKey struct{}
+
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal stored by NewContext, or nil.
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// Authorize checks that the principal stored in ctx may perform action on
+// key in appID. It fails closed when no principal was stored.
+func Authorize(ctx context.Context, appID, key string, action Action) error {
+	return FromContext(ctx).Authorize(appID, key, action)
+}
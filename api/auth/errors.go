@@ -0,0 +1,20 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/keanutaufan/kvstored/api/repository"
+)
+
+// Error kinds for failed authentication and authorization, matched with
+// errors.Is like the repository kinds.
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrForbidden       = errors.New("forbidden")
+)
+
+var (
+	ErrMissingCredential = repository.NewError(ErrUnauthenticated, "missing API key or bearer token")
+	ErrInvalidCredential = repository.NewError(ErrUnauthenticated, "invalid or revoked API key")
+	errNoPrincipal       = repository.NewError(ErrUnauthenticated, "request was not authenticated")
+)
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwksMaxAge is how long a key set is used before it is fetched again.
+	jwksMaxAge = time.Hour
+	// jwksMinRefresh rate limits refetches triggered by unknown key IDs, so
+	// tokens with made-up key IDs cannot hammer the identity provider.
+	jwksMinRefresh = time.Minute
+)
+
+// jwks is a JSON Web Key Set loaded from a file or an http(s) URL. The set
+// is fetched without holding mu, and by one caller at a time, so requests
+// signed with known keys are served from the cached set meanwhile.
+type jwks struct {
+	source     string
+	httpClient *http.Client
+	fetches    singleflight.Group
+
+	keys    map[string]crypto.PublicKey // key ID -> key
+	fetched time.Time
+	mu      sync.Mutex
+}
+
+func newJWKS(source string) (*jwks, error) {
+	set := &jwks{
+		source:     source,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	if err := set.refresh(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// key returns the key with the given ID. A stale set is refetched in the
+// background while its keys are still used; a set without the key is
+// refetched before giving up. A token without a key ID may use the only key
+// of a single-key set.
+func (s *jwks) key(kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	key, ok := s.lookup(kid)
+	age := time.Since(s.fetched)
+	s.mu.Unlock()
+
+	if ok {
+		if age > jwksMaxAge {
+			s.fetches.DoChan("", s.fetch)
+		}
+		return key, nil
+	}
+
+	if age > jwksMinRefresh {
+		if err := s.refresh(); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		key, ok = s.lookup(kid)
+		s.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("no key with ID %q in JWKS", kid)
+	}
+	return key, nil
+}
+
+// lookup finds a key in the cached set. s.mu must be held.
+func (s *jwks) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// refresh fetches the set, joining a fetch already in progress.
+func (s *jwks) refresh() error {
+	_, err, _ := s.fetches.Do("", s.fetch)
+	return err
+}
+
+func (s *jwks) fetch() (any, error) {
+	keys, err := s.load()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Failed fetches count too, so an unreachable provider is not retried
+	// on every request.
+	s.fetched = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	s.keys = keys
+	return nil, nil
+}
+
+func (s *jwks) load() (map[string]crypto.PublicKey, error) {
+	data, err := s.read()
+	if err != nil {
+		return nil, fmt.Errorf("loading JWKS from %s: %w", s.source, err)
+	}
+
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing JWKS from %s: %w", s.source, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, raw := range set.Keys {
+		kid, key, err := parseJWK(raw)
+		if err != nil {
+			// Skip keys of unsupported types rather than rejecting the
+			// whole set.
+			continue
+		}
+		keys[kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS from %s contains no usable signing keys", s.source)
+	}
+	return keys, nil
+}
+
+func (s *jwks) read() ([]byte, error) {
+	if !strings.HasPrefix(s.source, "http://") && !strings.HasPrefix(s.source, "https://") {
+		return os.ReadFile(s.source)
+	}
+
+	resp, err := s.httpClient.Get(s.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// parseJWK decodes an RSA, EC or Ed25519 public signing key.
+func parseJWK(raw json.RawMessage) (string, crypto.PublicKey, error) {
+	var jwk struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return "", nil, err
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return "", nil, errors.New("not a signing key")
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err1 := decodeBigInt(jwk.N)
+		e, err2 := decodeBigInt(jwk.E)
+		if err1 != nil || err2 != nil || !e.IsInt64() {
+			return "", nil, errors.New("invalid RSA key")
+		}
+		return jwk.Kid, &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return "", nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err1 := decodeBigInt(jwk.X)
+		y, err2 := decodeBigInt(jwk.Y)
+		if err1 != nil || err2 != nil || !curve.IsOnCurve(x, y) {
+			return "", nil, errors.New("invalid EC key")
+		}
+		return jwk.Kid, &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if jwk.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return "", nil, errors.New("invalid OKP key")
+		}
+		return jwk.Kid, ed25519.PublicKey(x), nil
+	}
+	return "", nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
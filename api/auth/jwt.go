@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/keanutaufan/kvstored/api/repository"
+)
+
+// Claims JWTConfig.ScopesClaim and JWTConfig.RolesClaim default to.
+const (
+	DefaultScopesClaim = "kvstored_scopes"
+	DefaultRolesClaim  = "kvstored_roles"
+)
+
+var jwtSigningMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+type JWTConfig struct {
+	// JWKS is the path or http(s) URL of the key set tokens are signed
+	// with, such as an OIDC provider's jwks_uri.
+	JWKS     string
+	Issuer   string
+	Audience string
+	// ScopesClaim names the claim holding the caller's apps, either as an
+	// object mapping app IDs to permissions, {"my-app": "write"}, or as a
+	// list or space separated string of "app:permission" entries.
+	ScopesClaim string
+	// RolesClaim names the claim holding the caller's role names, as a list
+	// or a space separated string.
+	RolesClaim string
+	// Leeway tolerates clock skew when checking exp, nbf and iat.
+	Leeway time.Duration
+}
+
+type jwtAuthenticator struct {
+	config JWTConfig
+	keys   *jwks
+	parser *jwt.Parser
+}
+
+// NewJWTAuthenticator returns an Authenticator for bearer JWTs. It loads the
+// key set immediately so misconfiguration is reported at startup.
+func NewJWTAuthenticator(config JWTConfig) (*jwtAuthenticator, error) {
+	if config.JWKS == "" || config.Issuer == "" || config.Audience == "" {
+		return nil, errors.New("JWT authentication requires a JWKS source, an issuer and an audience")
+	}
+	if config.ScopesClaim == "" {
+		config.ScopesClaim = DefaultScopesClaim
+	}
+	if config.RolesClaim == "" {
+		config.RolesClaim = DefaultRolesClaim
+	}
+
+	keys, err := newJWKS(config.JWKS)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jwtAuthenticator{
+		config: config,
+		keys:   keys,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(jwtSigningMethods),
+			jwt.WithIssuer(config.Issuer),
+			jwt.WithAudience(config.Audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+			jwt.WithLeeway(config.Leeway),
+		),
+	}, nil
+}
+
+func (a *jwtAuthenticator) Authenticate(ctx context.Context, credential string) (*Principal, error) {
+	if credential == "" {
+		return nil, ErrMissingCredential
+	}
+	if strings.Count(credential, ".") != 2 {
+		return nil, ErrInvalidCredential
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(credential, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return a.keys.key(kid)
+	})
+	if err != nil {
+		return nil, &repository.Error{Kind: ErrUnauthenticated, Message: "invalid token", Cause: err}
+	}
+
+	subject, _ := claims.GetSubject()
+	return &Principal{
+		Subject: "jwt:" + subject,
+		Scopes:  parseScopesClaim(claims[a.config.ScopesClaim]),
+		Roles:   parseRolesClaim(claims[a.config.RolesClaim]),
+	}, nil
+}
+
+func parseRolesClaim(claim any) []string {
+	switch claim := claim.(type) {
+	case []any:
+		var roles []string
+		for _, entry := range claim {
+			if role, ok := entry.(string); ok && role != "" {
+				roles = append(roles, role)
+			}
+		}
+		return roles
+	case string:
+		return strings.Fields(claim)
+	}
+	return nil
+}
+
+// parseScopesClaim reads the scopes claim in any of the forms JWTConfig
+// describes. Entries with unknown permissions are ignored.
+func parseScopesClaim(claim any) map[string]Permission {
+	scopes := make(map[string]Permission)
+	add := func(appID string, name any) {
+		s, ok := name.(string)
+		if !ok || appID == "" {
+			return
+		}
+		if permission, err := ParsePermission(s); err == nil {
+			scopes[appID] = max(scopes[appID], permission)
+		}
+	}
+	addEntry := func(entry any) {
+		// App IDs may contain colons, permissions never do.
+		s, _ := entry.(string)
+		if i := strings.LastIndexByte(s, ':'); i >= 0 {
+			add(s[:i], s[i+1:])
+		}
+	}
+
+	switch claim := claim.(type) {
+	case map[string]any:
+		for appID, name := range claim {
+			add(appID, name)
+		}
+	case []any:
+		for _, entry := range claim {
+			addEntry(entry)
+		}
+	case string:
+		for _, entry := range strings.Fields(claim) {
+			addEntry(entry)
+		}
+	}
+	return scopes
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/keanutaufan/kvstored/api/repository"
+)
+
+// Action is something a caller does to a key.
+type Action string
+
+const (
+	ActionRead      Action = "read"
+	ActionWrite     Action = "write"
+	ActionDelete    Action = "delete"
+	ActionSubscribe Action = "subscribe"
+)
+
+func ParseAction(name string) (Action, error) {
+	switch action := Action(strings.ToLower(name)); action {
+	case ActionRead, ActionWrite, ActionDelete, ActionSubscribe:
+		return action, nil
+	}
+	return "", repository.NewError(repository.ErrValidation, fmt.Sprintf("unknown action %q, must be read, write, delete or subscribe", name))
+}
+
+// allows reports whether an app-wide permission covers action: read lets
+// callers read and subscribe, write additionally lets them write and delete.
+func (p Permission) allows(action Action) bool {
+	switch action {
+	case ActionRead, ActionSubscribe:
+		return p >= Read
+	case ActionWrite, ActionDelete:
+		return p >= Write
+	}
+	return false
+}
+
+// Policy grants actions on the keys of an app, or of every app when AppID is
+// AllApps, that match Key. Key is either an exact key or a prefix followed
+// by "*", so "feature/*" matches every key starting with "feature/" and "*"
+// matches every key.
+type Policy struct {
+	AppID   string
+	Key     string
+	Actions []Action
+}
+
+// ValidateKeyPattern checks that "*" only appears at the end of pattern.
+func ValidateKeyPattern(pattern string) error {
+	if pattern == "" {
+		return repository.NewError(repository.ErrValidation, "key pattern cannot be empty")
+	}
+	if i := strings.IndexByte(pattern, '*'); i >= 0 && i != len(pattern)-1 {
+		return repository.NewError(repository.ErrValidation, fmt.Sprintf("key pattern %q may only end in *", pattern))
+	}
+	return nil
+}
+
+func (p Policy) grants(action Action) bool {
+	for _, granted := range p.Actions {
+		if granted == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) coversApp(appID string) bool {
+	return p.AppID == appID || p.AppID == AllApps
+}
+
+func (p Policy) coversKey(key string) bool {
+	if prefix, ok := strings.CutSuffix(p.Key, "*"); ok {
+		return strings.HasPrefix(key, prefix)
+	}
+	return p.Key == key
+}
+
+// Allows reports whether the caller may perform action on key in appID,
+// through either an app-wide permission or a policy. A nil principal may do
+// nothing.
+func (p *Principal) Allows(appID, key string, action Action) bool {
+	if p == nil {
+		return false
+	}
+	if p.Permission(appID).allows(action) {
+		return true
+	}
+	for _, policy := range p.Policies {
+		if policy.coversApp(appID) && policy.coversKey(key) && policy.grants(action) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAny reports whether the caller may perform action on at least some
+// keys in appID. Callers listing or watching a whole app must then check each
+// key with Allows.
+func (p *Principal) AllowsAny(appID string, action Action) bool {
+	if p == nil {
+		return false
+	}
+	if p.Permission(appID).allows(action) {
+		return true
+	}
+	for _, policy := range p.Policies {
+		if policy.coversApp(appID) && policy.grants(action) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize fails with ErrForbidden unless the caller may perform action on
+// key in appID.
+func (p *Principal) Authorize(appID, key string, action Action) error {
+	if p == nil {
+		return errNoPrincipal
+	}
+	if p.Allows(appID, key, action) {
+		return nil
+	}
+	return repository.NewError(ErrForbidden, fmt.Sprintf("%s may not %s key %q in app %q", p.Subject, action, key, appID))
+}
+
+// AuthorizeApp fails with ErrForbidden unless the caller may perform action
+// on at least some keys in appID.
+func (p *Principal) AuthorizeApp(appID string, action Action) error {
+	if p == nil {
+		return errNoPrincipal
+	}
+	if p.AllowsAny(appID, action) {
+		return nil
+	}
+	return repository.NewError(ErrForbidden, fmt.Sprintf("%s may not %s any key in app %q", p.Subject, action, appID))
+}
+
+// RoleResolver looks up the policies granted by named roles.
+type RoleResolver interface {
+	// RolePolicies returns the policies of roles. Roles that do not exist
+	// grant nothing.
+	RolePolicies(ctx context.Context, roles []string) ([]Policy, error)
+}
+
+type withRoles struct {
+	authenticator Authenticator
+	resolver      RoleResolver
+}
+
+// WithRoles returns an Authenticator that adds the policies of the roles
+// assigned to each principal authenticator returns.
+func WithRoles(authenticator Authenticator, resolver RoleResolver) Authenticator {
+	return withRoles{authenticator: authenticator, resolver: resolver}
+}
+
+func (a withRoles) Authenticate(ctx context.Context, credential string) (*Principal, error) {
+	principal, err := a.authenticator.Authenticate(ctx, credential)
+	if err != nil || len(principal.Roles) == 0 {
+		return principal, err
+	}
+
+	policies, err := a.resolver.RolePolicies(ctx, principal.Roles)
+	if err != nil {
+		return nil, err
+	}
+	principal.Policies = append(principal.Policies, policies...)
+	return principal, nil
+}
@@ -0,0 +1,227 @@
+// Package certs builds TLS configurations from certificate files and
+// reloads the files when they change, so certificates can be rotated
+// without restarting nodes.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Store holds a certificate and a CA bundle loaded from files. Files that
+// are not set are left out.
+type Store struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	interval time.Duration
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool // nil for the system's CAs
+	modTimes []time.Time
+	checked  time.Time
+}
+
+// New loads the certificate in certFile and keyFile and the CAs in caFile.
+// Whenever the files are used and they were last checked at least interval
+// ago, they are reloaded if they changed. A reload that fails is logged and
+// the files loaded before are kept. A zero interval never reloads them.
+func New(certFile, keyFile, caFile string, interval time.Duration) (*Store, error) {
+	s := &Store{certFile: certFile, keyFile: keyFile, caFile: caFile, interval: interval}
+	modTimes, err := s.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.load(modTimes); err != nil {
+		return nil, err
+	}
+	s.checked = time.Now()
+	return s, nil
+}
+
+func (s *Store) files() []string {
+	var files []string
+	for _, file := range []string{s.certFile, s.keyFile, s.caFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+func (s *Store) stat() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, file := range s.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+func (s *Store) load(modTimes []time.Time) error {
+	var cert *tls.Certificate
+	if s.certFile != "" || s.keyFile != "" {
+		loaded, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+		if err != nil {
+			return fmt.Errorf("loading certificate %s: %w", s.certFile, err)
+		}
+		cert = &loaded
+	}
+
+	var pool *x509.CertPool
+	if s.caFile != "" {
+		pem, err := os.ReadFile(s.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", s.caFile)
+		}
+	}
+
+	s.cert, s.pool, s.modTimes = cert, pool, modTimes
+	return nil
+}
+
+// current returns the certificate and CAs, reloading them first if they are
+// due to be checked and changed.
+func (s *Store) current() (*tls.Certificate, *x509.CertPool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.interval > 0 && time.Since(s.checked) >= s.interval {
+		s.checked = time.Now()
+		modTimes, err := s.stat()
+		if err == nil && changed(s.modTimes, modTimes) {
+			if err = s.load(modTimes); err == nil {
+				slog.Info("Reloaded TLS certificates", "files", s.files())
+			}
+		}
+		if err != nil {
+			slog.Error("Failed to reload TLS certificates, keeping the ones loaded before", "files", s.files(), "error", err)
+		}
+	}
+	return s.cert, s.pool
+}
+
+func changed(before, after []time.Time) bool {
+	for i := range before {
+		if !before[i].Equal(after[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServerConfig returns a configuration serving the store's certificate and
+// applying clientAuth to client certificates, which are verified against
+// the store's CAs.
+func (s *Store) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := s.current()
+			return cert, nil
+		},
+	}
+
+	// Client certificates are requested without verification and verified
+	// by VerifyConnection instead, as ClientCAs cannot be swapped once the
+	// configuration is in use.
+	switch clientAuth {
+	case tls.VerifyClientCertIfGiven:
+		config.ClientAuth = tls.RequestClientCert
+	case tls.RequireAndVerifyClientCert:
+		config.ClientAuth = tls.RequireAnyClientCert
+	default:
+		config.ClientAuth = clientAuth
+		return config
+	}
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return nil
+		}
+		_, pool := s.current()
+		return verify(state.PeerCertificates, pool, "", x509.ExtKeyUsageClientAuth)
+	}
+	return config
+}
+
+// ClientConfig returns a configuration verifying servers against the store's
+// CAs and serverName, which may be a host name or an IP address. The store's
+// certificate is presented to servers asking for one. Without verifyServer,
+// any server certificate is accepted.
+//
+// The name is checked by the returned configuration itself rather than
+// taken from the connection, whose server name is empty for IP addresses,
+// so a configuration must only be used for connections to serverName.
+// Client sets one up for each connection.
+func (s *Store) ClientConfig(serverName string, verifyServer bool) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		// Servers are verified by VerifyConnection instead, as RootCAs
+		// cannot be swapped once the configuration is in use.
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := s.current()
+			if cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+	}
+	if verifyServer {
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			_, pool := s.current()
+			return verify(state.PeerCertificates, pool, serverName, x509.ExtKeyUsageServerAuth)
+		}
+	}
+	return config
+}
+
+// Client runs the TLS handshake over conn, a connection to host, verifying
+// the server against serverName, or host when it is empty.
+func (s *Store) Client(ctx context.Context, conn net.Conn, host, serverName string, verifyServer bool) (net.Conn, error) {
+	if serverName == "" {
+		serverName = host
+	}
+	tlsConn := tls.Client(conn, s.ClientConfig(serverName, verifyServer))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// verify checks that certs chain to a CA in pool, or the system's CAs when
+// pool is nil, and, unless name is empty, that they are valid for name.
+func verify(certs []*x509.Certificate, pool *x509.CertPool, name string, usage x509.ExtKeyUsage) error {
+	if len(certs) == 0 {
+		return errors.New("no certificate presented")
+	}
+	options := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       name,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range certs[1:] {
+		options.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(options)
+	return err
+}
@@ -0,0 +1,261 @@
+// Package client is a Go SDK for the kvstored HTTP API and its socket.io
+// change notifications.
+//
+//	c, err := client.New([]string{"http://localhost:8001", "http://localhost:8002"})
+//	if err != nil { ... }
+//	err = c.Set(ctx, "my-app", "greeting", "hello")
+//	kv, err := c.Get(ctx, "my-app", "greeting")
+//
+// Requests that fail because a node is unreachable or overloaded are retried
+// with exponential backoff, failing over to the next endpoint.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/keanutaufan/kvstored/api/dto"
+	"github.com/keanutaufan/kvstored/api/entity"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 2 * time.Second
+)
+
+type Client struct {
+	endpoints  []string
+	httpClient *http.Client
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	apiKey     string
+
+	// current is the index of the endpoint requests are sent to. It moves
+	// on to the next endpoint whenever the current one fails.
+	current atomic.Uint32
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests. It defaults to a
+// client with a 10 second timeout.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAPIKey authenticates requests and watches with an API key.
+func WithAPIKey(apiKey string) Option {
+	return func(c *Client) {
+		c.apiKey = apiKey
+	}
+}
+
+// WithRetries sets how many times a failed request is retried. Zero disables
+// retries.
+func WithRetries(maxRetries int) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+	}
+}
+
+// WithBackoff sets the delay before the first retry and the cap the delay
+// doubles up to.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Client) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+// New returns a client for the given base URLs, such as
+// "http://localhost:8001". Requests go to the first endpoint until it fails.
+func New(endpoints []string, opts ...Option) (*Client, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("client: at least one endpoint is required")
+	}
+
+	c := &Client{
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		maxRetries: defaultMaxRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, endpoint := range endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("client: invalid endpoint %q", endpoint)
+		}
+		c.endpoints = append(c.endpoints, strings.TrimSuffix(endpoint, "/"))
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Get returns the value stored under key.
+func (c *Client) Get(ctx context.Context, appID, key string) (entity.KeyValue, error) {
+	var keyValue entity.KeyValue
+	err := c.do(ctx, http.MethodGet, keyPath(appID, key), nil, &keyValue)
+	return keyValue, err
+}
+
+// GetAll returns every value of an app. An app without keys yields an empty
+// slice rather than an error.
+func (c *Client) GetAll(ctx context.Context, appID string) ([]entity.KeyValue, error) {
+	var keyValues []entity.KeyValue
+	err := c.do(ctx, http.MethodGet, "/kv/"+url.PathEscape(appID), nil, &keyValues)
+	if errors.Is(err, ErrNotFound) {
+		return []entity.KeyValue{}, nil
+	}
+	return keyValues, err
+}
+
+// Set stores value under key, creating or overwriting it.
+func (c *Client) Set(ctx context.Context, appID, key, value string) error {
+	return c.do(ctx, http.MethodPut, keyPath(appID, key), dto.KeyValueBodyRequest{Value: value}, nil)
+}
+
+// SetKeyValue stores keyValue.Value under keyValue.Key of keyValue.AppID
+// together with its content type and TTL, replacing any expiry the key had.
+// The version and creation time are assigned by the server.
+func (c *Client) SetKeyValue(ctx context.Context, keyValue entity.KeyValue) error {
+	body := dto.KeyValueBodyRequest{
+		Value:       keyValue.Value,
+		ContentType: keyValue.ContentType,
+		TTL:         &keyValue.TTL,
+	}
+	return c.do(ctx, http.MethodPut, keyPath(keyValue.AppID, keyValue.Key), body, nil)
+}
+
+// Update overwrites the value of an existing key. It fails with ErrNotFound
+// when the key does not exist.
+func (c *Client) Update(ctx context.Context, appID, key, value string) error {
+	return c.do(ctx, http.MethodPatch, keyPath(appID, key), dto.KeyValueBodyRequest{Value: value}, nil)
+}
+
+// Delete removes key. Deleting a key that does not exist is not an error.
+func (c *Client) Delete(ctx context.Context, appID, key string) error {
+	return c.do(ctx, http.MethodDelete, keyPath(appID, key), nil, nil)
+}
+
+func keyPath(appID, key string) string {
+	return "/kv/" + url.PathEscape(appID) + "/" + url.PathEscape(key)
+}
+
+// do sends a request, retrying it on another endpoint when the node could
+// not be reached or reported a temporary failure. A successful response body
+// is decoded into out when out is not nil.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		index := c.current.Load()
+		retryAfter, err := c.send(ctx, c.endpoints[index%uint32(len(c.endpoints))], method, path, payload, out)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var apiErr *Error
+		if errors.As(err, &apiErr) && !apiErr.temporary() {
+			return err
+		}
+		if attempt >= c.maxRetries {
+			return err
+		}
+
+		c.current.CompareAndSwap(index, index+1)
+
+		delay := c.backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// send performs a single request and returns the delay the server asked for
+// in a Retry-After header, if any.
+func (c *Client) send(ctx context.Context, endpoint, method, path string, payload []byte, out any) (time.Duration, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+path, body)
+	if err != nil {
+		return 0, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var retryAfter time.Duration
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return retryAfter, readError(resp)
+	}
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return 0, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return 0, fmt.Errorf("client: decoding response: %w", err)
+	}
+	return 0, nil
+}
+
+// backoff returns a random delay of up to minBackoff doubled attempt times,
+// capped at maxBackoff.
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.minBackoff << min(attempt, 30)
+	if delay <= 0 || delay > c.maxBackoff {
+		delay = c.maxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/keanutaufan/kvstored/api/dto"
+)
+
+// Error kinds returned by the client. Match them with errors.Is; the *Error
+// carrying the server's response can be retrieved with errors.As.
+var (
+	ErrInvalidRequest     = errors.New("invalid request")
+	ErrUnauthenticated    = errors.New("unauthenticated")
+	ErrForbidden          = errors.New("forbidden")
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrUnavailable        = errors.New("unavailable")
+	ErrTimeout            = errors.New("timeout")
+)
+
+// Error is an error response from the server.
+type Error struct {
+	StatusCode int
+	Code       string // problem code, such as "not_found"
+	Title      string
+	Detail     string
+}
+
+func (e *Error) Error() string {
+	message := e.Title
+	if message == "" {
+		message = http.StatusText(e.StatusCode)
+	}
+	if e.Detail != "" {
+		message += ": " + e.Detail
+	}
+	return fmt.Sprintf("kvstored: %s (%d)", message, e.StatusCode)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrInvalidRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthenticated:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrPreconditionFailed:
+		return e.StatusCode == http.StatusPreconditionFailed
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable || e.StatusCode == http.StatusBadGateway
+	case ErrTimeout:
+		return e.StatusCode == http.StatusGatewayTimeout
+	}
+	return false
+}
+
+// temporary reports whether the request may succeed when retried.
+func (e *Error) temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// readError builds an *Error from a response, understanding both problem
+// details bodies and the plain {"error": "..."} bodies of older servers.
+func readError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil || len(body) == 0 {
+		return apiErr
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/problem+json":
+		var problem dto.Problem
+		if json.Unmarshal(body, &problem) == nil {
+			apiErr.Code = problem.Code
+			apiErr.Title = problem.Title
+			apiErr.Detail = problem.Detail
+		}
+	case strings.HasSuffix(mediaType, "json"):
+		var legacy struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &legacy) == nil {
+			apiErr.Detail = legacy.Error
+		}
+	default:
+		apiErr.Detail = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
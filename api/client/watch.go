@@ -0,0 +1,233 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/googollee/go-socket.io/engineio"
+	"github.com/googollee/go-socket.io/engineio/session"
+	"github.com/googollee/go-socket.io/engineio/transport"
+	"github.com/googollee/go-socket.io/engineio/transport/websocket"
+	"github.com/keanutaufan/kvstored/api/entity"
+)
+
+type EventType string
+
+const (
+	EventSet    EventType = "set"
+	EventUpdate EventType = "update"
+	EventDelete EventType = "delete"
+)
+
+// Event is a change to a watched key. Value is nil for deletions.
+type Event struct {
+	Type  EventType
+	AppID string
+	Key   string
+	Value *entity.KeyValue
+}
+
+var socketEvents = map[string]EventType{
+	"key_set":     EventSet,
+	"key_updated": EventUpdate,
+	"key_deleted": EventDelete,
+}
+
+// Watch streams changes to key, or to every key of the app when key is
+// empty. The connection is re-established on another endpoint whenever it
+// drops or the server shuts down; changes made while disconnected are not
+// replayed. The channel is closed once ctx is done or the server refuses the
+// subscription because the API key may not subscribe to the key or app.
+// Subscriptions refused by rate limits are retried once the server allows.
+func (c *Client) Watch(ctx context.Context, appID, key string) (<-chan Event, error) {
+	if appID == "" {
+		return nil, errors.New("client: app ID is required")
+	}
+
+	conn, err := c.subscribe(ctx, appID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+
+		for attempt := 0; ; {
+			var retryAfter time.Duration
+			if conn != nil {
+				attempt = 0
+				refused, limited := c.forward(ctx, conn, events)
+				if refused {
+					return
+				}
+				retryAfter = limited
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			timer := time.NewTimer(max(c.backoff(attempt), retryAfter))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			attempt++
+
+			conn, _ = c.subscribe(ctx, appID, key)
+		}
+	}()
+	return events, nil
+}
+
+// subscribe connects to the first reachable endpoint and subscribes to the
+// key or app.
+func (c *Client) subscribe(ctx context.Context, appID, key string) (engineio.Conn, error) {
+	dialer := engineio.Dialer{
+		Transports: []transport.Transport{&websocket.Transport{HandshakeTimeout: 10 * time.Second}},
+	}
+	var header http.Header
+	if c.apiKey != "" {
+		header = http.Header{"Authorization": {"Bearer " + c.apiKey}}
+	}
+
+	var lastErr error
+	for range c.endpoints {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		index := c.current.Load()
+		conn, err := dialer.Dial(c.endpoints[index%uint32(len(c.endpoints))]+"/socket.io/", header)
+		if err == nil {
+			if key == "" {
+				err = emit(conn, "subscribe_app", appID)
+			} else {
+				err = emit(conn, "subscribe_key", appID, key)
+			}
+			if err == nil {
+				return conn, nil
+			}
+			conn.Close()
+		}
+
+		lastErr = err
+		c.current.CompareAndSwap(index, index+1)
+	}
+	return nil, lastErr
+}
+
+// forward delivers the events received on conn until the connection drops or
+// ctx is done. It reports whether the server refused the subscription, or
+// how long to wait when it was rate limited instead.
+func (c *Client) forward(ctx context.Context, conn engineio.Conn, events chan<- Event) (bool, time.Duration) {
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+	defer conn.Close()
+
+	for {
+		_, r, err := conn.NextReader()
+		if err != nil {
+			return false, 0
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return false, 0
+		}
+
+		if strings.HasPrefix(string(data), `2["shutdown"`) {
+			// The node is stopping; move on to the next endpoint.
+			c.current.Add(1)
+			return false, 0
+		}
+		if strings.HasPrefix(string(data), `2["subscribe_error"`) {
+			if retryAfter := parseRetryAfter(string(data)); retryAfter > 0 {
+				return false, retryAfter
+			}
+			return true, 0
+		}
+		event, ok := parseEvent(string(data))
+		if !ok {
+			continue
+		}
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return false, 0
+		}
+	}
+}
+
+// parseRetryAfter returns the retry_after of a subscribe_error packet, which
+// the server only sets when the subscription was rate limited.
+func parseRetryAfter(packet string) time.Duration {
+	var args []json.RawMessage
+	if json.Unmarshal([]byte(strings.TrimPrefix(packet, "2")), &args) != nil || len(args) < 2 {
+		return 0
+	}
+	var payload struct {
+		RetryAfter float64 `json:"retry_after"`
+	}
+	json.Unmarshal(args[1], &payload)
+	return time.Duration(payload.RetryAfter * float64(time.Second))
+}
+
+// emit sends a socket.io event packet on the default namespace.
+func emit(conn engineio.Conn, name string, args ...string) error {
+	packet, err := json.Marshal(append([]string{name}, args...))
+	if err != nil {
+		return err
+	}
+
+	w, err := conn.NextWriter(session.TEXT)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append([]byte("2"), packet...)); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// parseEvent decodes a socket.io event packet such as
+// 2["key_set",{"app_id":"...","key":"...",...}].
+func parseEvent(packet string) (Event, bool) {
+	if !strings.HasPrefix(packet, "2") {
+		return Event{}, false
+	}
+
+	var args []json.RawMessage
+	if err := json.Unmarshal([]byte(packet[1:]), &args); err != nil || len(args) != 2 {
+		return Event{}, false
+	}
+	var name string
+	if err := json.Unmarshal(args[0], &name); err != nil {
+		return Event{}, false
+	}
+	eventType, ok := socketEvents[name]
+	if !ok {
+		return Event{}, false
+	}
+
+	var keyValue entity.KeyValue
+	if err := json.Unmarshal(args[1], &keyValue); err != nil {
+		return Event{}, false
+	}
+
+	event := Event{Type: eventType, AppID: keyValue.AppID, Key: keyValue.Key}
+	if eventType != EventDelete {
+		event.Value = &keyValue
+	}
+	return event, true
+}
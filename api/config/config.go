@@ -0,0 +1,428 @@
+// Package config loads the node's configuration from defaults, a YAML or
+// TOML file, environment variables and command line flags, each overriding
+// the ones before it.
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/joho/godotenv"
+	"github.com/keanutaufan/kvstored/api/logging"
+)
+
+// Config is the configuration of a node. Every setting has a key, its dotted
+// path in configuration files and its flag name, and usually an environment
+// variable. Settings marked secret are redacted by Print.
+type Config struct {
+	Env    string `key:"env" env:"APP_ENV" usage:"environment; .env is only read outside production"`
+	NodeID string `key:"node_id" env:"NODE_ID" usage:"unique name of this node"`
+
+	Log        Log        `key:"log"`
+	Tracing    Tracing    `key:"tracing"`
+	HTTP       HTTP       `key:"http"`
+	Socket     Socket     `key:"socket"`
+	Watch      Watch      `key:"watch"`
+	GRPC       GRPC       `key:"grpc"`
+	RESP       RESP       `key:"resp"`
+	Memcached  Memcached  `key:"memcached"`
+	Shutdown   Shutdown   `key:"shutdown"`
+	Cassandra  Cassandra  `key:"cassandra"`
+	Kafka      Kafka      `key:"kafka"`
+	Auth       Auth       `key:"auth"`
+	RateLimit  RateLimit  `key:"rate_limit"`
+	Validation Validation `key:"validation"`
+}
+
+type Log struct {
+	Format string `key:"format" env:"LOG_FORMAT" usage:"log format, json or text"`
+	Level  string `key:"level" env:"LOG_LEVEL" usage:"minimum level logged: debug, info, warn or error"`
+}
+
+type Tracing struct {
+	Enabled  bool   `key:"enabled" env:"TRACING_ENABLED" usage:"export traces over OTLP"`
+	Endpoint string `key:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" usage:"OTLP/gRPC collector URL, such as http://localhost:4317"`
+}
+
+type HTTP struct {
+	Port              int           `key:"port" env:"PORT" usage:"HTTP port"`
+	ReadHeaderTimeout time.Duration `key:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT" usage:"time allowed to read request headers"`
+	TrustedProxies    []string      `key:"trusted_proxies" env:"HTTP_TRUSTED_PROXIES" usage:"IPs or CIDRs of proxies whose X-Forwarded-For header is trusted for client IPs; none when empty"`
+	TLS               ServerTLS     `key:"tls" env:"HTTP_TLS_"`
+}
+
+// ServerTLS configures TLS for connections clients make to kvstored.
+type ServerTLS struct {
+	Enabled        bool          `key:"enabled" env:"ENABLED" usage:"serve TLS"`
+	CertFile       string        `key:"cert_file" env:"CERT_FILE" usage:"PEM certificate chain"`
+	KeyFile        string        `key:"key_file" env:"KEY_FILE" usage:"PEM private key"`
+	ClientCAFile   string        `key:"client_ca_file" env:"CLIENT_CA_FILE" usage:"PEM bundle of the CAs client certificates are verified against"`
+	ClientAuth     string        `key:"client_auth" env:"CLIENT_AUTH" usage:"client certificate policy: none, request, require, verify_if_given or require_and_verify"`
+	ReloadInterval time.Duration `key:"reload_interval" env:"RELOAD_INTERVAL" usage:"how often the files are checked for changes; 0 never reloads them"`
+}
+
+// ClientTLS configures TLS for connections kvstored makes.
+type ClientTLS struct {
+	Enabled            bool          `key:"enabled" env:"ENABLED" usage:"connect with TLS"`
+	CAFile             string        `key:"ca_file" env:"CA_FILE" usage:"PEM bundle of the CAs server certificates are verified against; the system's when empty"`
+	CertFile           string        `key:"cert_file" env:"CERT_FILE" usage:"PEM client certificate chain, for servers requiring client certificates"`
+	KeyFile            string        `key:"key_file" env:"KEY_FILE" usage:"PEM private key of the client certificate"`
+	ServerName         string        `key:"server_name" env:"SERVER_NAME" usage:"name server certificates must be valid for, instead of the host connected to"`
+	InsecureSkipVerify bool          `key:"insecure_skip_verify" env:"INSECURE_SKIP_VERIFY" usage:"accept any server certificate"`
+	ReloadInterval     time.Duration `key:"reload_interval" env:"RELOAD_INTERVAL" usage:"how often the files are checked for changes; 0 never reloads them"`
+}
+
+type Socket struct {
+	PingInterval time.Duration `key:"ping_interval" env:"SOCKET_PING_INTERVAL" usage:"interval between socket.io pings"`
+	PingTimeout  time.Duration `key:"ping_timeout" env:"SOCKET_PING_TIMEOUT" usage:"time after which a socket.io client missing pings is disconnected"`
+}
+
+type Watch struct {
+	HeartbeatInterval time.Duration `key:"heartbeat_interval" env:"WATCH_HEARTBEAT_INTERVAL" usage:"interval between heartbeats on idle server-sent event streams"`
+	ReplayBuffer      int           `key:"replay_buffer" env:"WATCH_REPLAY_BUFFER" usage:"number of recent changes kept to stream to watchers and replay to reconnecting ones; must be positive"`
+}
+
+type GRPC struct {
+	Port int `key:"port" env:"GRPC_PORT" usage:"gRPC port"`
+}
+
+type RESP struct {
+	Port int `key:"port" env:"RESP_PORT" usage:"RESP (Redis protocol) port"`
+}
+
+type Memcached struct {
+	Port  int    `key:"port" env:"MEMCACHED_PORT" usage:"memcached protocol port"`
+	AppID string `key:"app_id" env:"MEMCACHED_APP_ID" usage:"app whose keys the memcached protocol serves"`
+}
+
+type Shutdown struct {
+	DrainDelay time.Duration `key:"drain_delay" env:"DRAIN_DELAY" usage:"time to report not ready before shutting down"`
+	Timeout    time.Duration `key:"timeout" env:"SHUTDOWN_TIMEOUT" usage:"time allowed to finish requests and publishes when shutting down"`
+}
+
+type Cassandra struct {
+	Hosts          []string      `key:"hosts" env:"CASSANDRA_HOSTS" usage:"comma separated Cassandra hosts"`
+	Keyspace       string        `key:"keyspace" env:"CASSANDRA_KEYSPACE" usage:"keyspace holding kvstored's tables"`
+	Consistency    string        `key:"consistency" env:"CASSANDRA_CONSISTENCY" usage:"consistency level of queries, such as quorum or local_quorum"`
+	Timeout        time.Duration `key:"timeout" env:"CASSANDRA_TIMEOUT" usage:"query timeout"`
+	ConnectTimeout time.Duration `key:"connect_timeout" env:"CASSANDRA_CONNECT_TIMEOUT" usage:"connection timeout"`
+	Username       string        `key:"username" env:"CASSANDRA_USERNAME" usage:"username for password authentication"`
+	Password       string        `key:"password" env:"CASSANDRA_PASSWORD" usage:"password for password authentication" secret:"true"`
+	TLS            ClientTLS     `key:"tls" env:"CASSANDRA_TLS_"`
+}
+
+type Kafka struct {
+	Brokers []string  `key:"brokers" env:"KAFKA_HOSTS" usage:"comma separated Kafka brokers"`
+	Topic   string    `key:"topic" env:"KAFKA_TOPIC" usage:"topic key changes are published to"`
+	GroupID string    `key:"group_id" env:"KAFKA_GROUP_ID" usage:"consumer group, kvstored-group-<node_id> by default"`
+	TLS     ClientTLS `key:"tls" env:"KAFKA_TLS_"`
+	SASL    SASL      `key:"sasl" env:"KAFKA_SASL_"`
+}
+
+type SASL struct {
+	Mechanism string `key:"mechanism" env:"MECHANISM" usage:"SASL mechanism: plain, scram-sha-256 or scram-sha-512; no SASL when empty"`
+	Username  string `key:"username" env:"USERNAME" usage:"SASL username"`
+	Password  string `key:"password" env:"PASSWORD" usage:"SASL password" secret:"true"`
+}
+
+type Auth struct {
+	Enabled     bool   `key:"enabled" env:"AUTH_ENABLED" usage:"require credentials; when false every caller is an admin"`
+	AdminAPIKey string `key:"admin_api_key" env:"ADMIN_API_KEY" usage:"bootstrap API key with admin permission on every app" secret:"true"`
+	JWT         JWT    `key:"jwt"`
+}
+
+type JWT struct {
+	JWKS        string        `key:"jwks" env:"JWT_JWKS" usage:"JWKS file or URL; JWT authentication is off when empty"`
+	Issuer      string        `key:"issuer" env:"JWT_ISSUER" usage:"required iss claim"`
+	Audience    string        `key:"audience" env:"JWT_AUDIENCE" usage:"required aud claim"`
+	ScopesClaim string        `key:"scopes_claim" env:"JWT_SCOPES_CLAIM" usage:"claim holding app scopes"`
+	RolesClaim  string        `key:"roles_claim" env:"JWT_ROLES_CLAIM" usage:"claim holding role names"`
+	Leeway      time.Duration `key:"leeway" env:"JWT_LEEWAY" usage:"clock skew allowed when checking exp and nbf"`
+}
+
+type RateLimit struct {
+	Enabled bool   `key:"enabled" env:"RATE_LIMIT_ENABLED" usage:"enforce rate limits"`
+	Shared  bool   `key:"shared" env:"RATE_LIMIT_SHARED" usage:"share rate limit counters between nodes through Cassandra"`
+	Rules   string `key:"rules" env:"RATE_LIMITS" usage:"rules overriding the default limits, such as client:*:write=20/s:40"`
+}
+
+type Validation struct {
+	PolicyFile string `key:"policy_file" env:"VALIDATION_POLICY_FILE" usage:"JSON file of key and value validation policies"`
+}
+
+// Default returns the configuration used for settings no source sets.
+func Default() Config {
+	return Config{
+		Env:    "development",
+		NodeID: "kvstored1",
+		Log:    Log{Format: "json", Level: "info"},
+		HTTP: HTTP{
+			Port:              8000,
+			ReadHeaderTimeout: 10 * time.Second,
+			TLS:               ServerTLS{ClientAuth: "none", ReloadInterval: time.Minute},
+		},
+		Socket: Socket{
+			PingInterval: 20 * time.Second,
+			PingTimeout:  time.Minute,
+		},
+		Watch: Watch{
+			HeartbeatInterval: 15 * time.Second,
+			ReplayBuffer:      1024,
+		},
+		GRPC:      GRPC{Port: 50051},
+		RESP:      RESP{Port: 6379},
+		Memcached: Memcached{Port: 11211, AppID: "default"},
+		Shutdown: Shutdown{
+			DrainDelay: 5 * time.Second,
+			Timeout:    30 * time.Second,
+		},
+		Cassandra: Cassandra{
+			Hosts:          []string{"localhost"},
+			Keyspace:       "kv_store_app",
+			Consistency:    "quorum",
+			Timeout:        11 * time.Second,
+			ConnectTimeout: 11 * time.Second,
+			TLS:            ClientTLS{ReloadInterval: time.Minute},
+		},
+		Kafka: Kafka{
+			Brokers: []string{"localhost"},
+			Topic:   "kvstore",
+			TLS:     ClientTLS{ReloadInterval: time.Minute},
+		},
+		Auth: Auth{
+			Enabled: true,
+			JWT:     JWT{Leeway: 30 * time.Second},
+		},
+		RateLimit: RateLimit{Enabled: true},
+	}
+}
+
+// Load reads the configuration. Outside production, variables in a .env
+// file, if there is one, are added to the environment first. Then the
+// defaults are overridden by the file named by the -config flag or the
+// CONFIG_FILE variable, by environment variables and by the flags in args,
+// which are parsed with flags. Flags the caller defined on flags are parsed
+// too.
+func Load(flags *flag.FlagSet, args []string) (Config, error) {
+	if os.Getenv("APP_ENV") != "production" {
+		if err := godotenv.Load(".env"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return Config{}, fmt.Errorf("reading .env: %w", err)
+		}
+	}
+
+	cfg := Default()
+	settings := cfg.settings()
+
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration `file` (env CONFIG_FILE)")
+	flagValues := make(map[string]string)
+	var flagOrder []string
+	for _, s := range settings {
+		usage := s.usage
+		if s.env != "" {
+			usage += " (env " + s.env + ")"
+		}
+		if def := s.String(); def != "" && !s.secret {
+			usage += fmt.Sprintf(" (default %q)", def)
+		}
+		set := func(value string) error {
+			// Parse now so flag errors are reported like any other.
+			if err := s.check(value); err != nil {
+				return err
+			}
+			if _, ok := flagValues[s.key]; !ok {
+				flagOrder = append(flagOrder, s.key)
+			}
+			flagValues[s.key] = value
+			return nil
+		}
+		if s.value.Kind() == reflect.Bool {
+			// So -auth.enabled means -auth.enabled=true.
+			flags.BoolFunc(s.key, usage, set)
+		} else {
+			flags.Func(s.key, usage, set)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(settings, *configFile); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		if value := os.Getenv(s.env); s.env != "" && value != "" {
+			if err := s.Set(value); err != nil {
+				return Config{}, fmt.Errorf("environment variable %s: %w", s.env, err)
+			}
+		}
+	}
+
+	byKey := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+	for _, key := range flagOrder {
+		// Checked while parsing.
+		byKey[key].Set(flagValues[key])
+	}
+
+	if cfg.Kafka.GroupID == "" {
+		cfg.Kafka.GroupID = "kvstored-group-" + cfg.NodeID
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+var keyspacePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{0,47}$`)
+
+// Validate reports every invalid setting, each prefixed with its key. Rate
+// limit rules are checked by ratelimit when they are parsed.
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if c.NodeID == "" {
+		invalid("node_id", "cannot be empty")
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		invalid("log.format", "must be json or text, not %q", c.Log.Format)
+	}
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		invalid("log.level", "%v", err)
+	}
+
+	ports := []struct {
+		key  string
+		port int
+	}{
+		{"http.port", c.HTTP.Port},
+		{"grpc.port", c.GRPC.Port},
+		{"resp.port", c.RESP.Port},
+		{"memcached.port", c.Memcached.Port},
+	}
+	for _, p := range ports {
+		if p.port < 1 || p.port > 65535 {
+			invalid(p.key, "must be between 1 and 65535, not %d", p.port)
+		}
+	}
+	for _, proxy := range c.HTTP.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			invalid("http.trusted_proxies", "%q is not an IP or CIDR", proxy)
+		}
+	}
+	if c.Watch.HeartbeatInterval <= 0 {
+		invalid("watch.heartbeat_interval", "must be positive")
+	}
+	if c.Watch.ReplayBuffer <= 0 {
+		invalid("watch.replay_buffer", "must be positive")
+	}
+	if c.Memcached.AppID == "" {
+		invalid("memcached.app_id", "cannot be empty")
+	}
+
+	for _, s := range c.settings() {
+		if d, ok := s.value.Interface().(time.Duration); ok && d < 0 {
+			invalid(s.key, "cannot be negative")
+		}
+	}
+
+	if len(c.Cassandra.Hosts) == 0 {
+		invalid("cassandra.hosts", "cannot be empty")
+	}
+	if !keyspacePattern.MatchString(c.Cassandra.Keyspace) {
+		invalid("cassandra.keyspace", "must be a letter followed by up to 47 letters, digits or underscores, not %q", c.Cassandra.Keyspace)
+	}
+	if _, err := c.Cassandra.ParseConsistency(); err != nil {
+		invalid("cassandra.consistency", "unknown consistency %q", c.Cassandra.Consistency)
+	}
+	if c.Cassandra.Password != "" && c.Cassandra.Username == "" {
+		invalid("cassandra.username", "must be set with cassandra.password")
+	}
+
+	c.HTTP.TLS.validate("http.tls", invalid)
+	c.Cassandra.TLS.validate("cassandra.tls", invalid)
+	c.Kafka.TLS.validate("kafka.tls", invalid)
+
+	if len(c.Kafka.Brokers) == 0 {
+		invalid("kafka.brokers", "cannot be empty")
+	}
+	if c.Kafka.Topic == "" {
+		invalid("kafka.topic", "cannot be empty")
+	}
+	switch c.Kafka.SASL.Mechanism {
+	case "":
+	case "plain", "scram-sha-256", "scram-sha-512":
+		if c.Kafka.SASL.Username == "" {
+			invalid("kafka.sasl.username", "must be set with kafka.sasl.mechanism")
+		}
+	default:
+		invalid("kafka.sasl.mechanism", "must be plain, scram-sha-256 or scram-sha-512, not %q", c.Kafka.SASL.Mechanism)
+	}
+
+	if c.Auth.JWT.JWKS != "" && (c.Auth.JWT.Issuer == "" || c.Auth.JWT.Audience == "") {
+		invalid("auth.jwt", "issuer and audience must be set with jwks")
+	}
+
+	return errors.Join(errs...)
+}
+
+// ParseConsistency returns the gocql consistency named by Consistency.
+func (c Cassandra) ParseConsistency() (gocql.Consistency, error) {
+	return gocql.ParseConsistencyWrapper(strings.ToUpper(c.Consistency))
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// ClientAuthType returns the client certificate policy named by ClientAuth.
+func (t ServerTLS) ClientAuthType() (tls.ClientAuthType, error) {
+	clientAuth, ok := clientAuthTypes[t.ClientAuth]
+	if !ok {
+		return 0, fmt.Errorf("unknown client certificate policy %q", t.ClientAuth)
+	}
+	return clientAuth, nil
+}
+
+func (t ServerTLS) validate(key string, invalid func(key, format string, args ...any)) {
+	clientAuth, err := t.ClientAuthType()
+	if err != nil {
+		invalid(key+".client_auth", "must be none, request, require, verify_if_given or require_and_verify, not %q", t.ClientAuth)
+	}
+	if !t.Enabled {
+		return
+	}
+	if t.CertFile == "" || t.KeyFile == "" {
+		invalid(key, "cert_file and key_file must be set when TLS is enabled")
+	}
+	if clientAuth >= tls.VerifyClientCertIfGiven && t.ClientCAFile == "" {
+		invalid(key+".client_ca_file", "must be set to verify client certificates")
+	}
+}
+
+func (t ClientTLS) validate(key string, invalid func(key, format string, args ...any)) {
+	if t.Enabled && (t.CertFile == "") != (t.KeyFile == "") {
+		invalid(key, "cert_file and key_file must be set together")
+	}
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// loadFile applies the settings in a YAML or TOML file, chosen by its
+// extension. Sections nest as in Config, so the file may contain
+//
+//	cassandra:
+//	  hosts: [cassandra1, cassandra2]
+//	  keyspace: kv_store_app
+func loadFile(settings []setting, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading configuration: %w", err)
+	}
+
+	document := make(map[string]any)
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &document)
+	case ".toml":
+		err = toml.Unmarshal(data, &document)
+	default:
+		return fmt.Errorf("configuration file %s must end in .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	if err := flatten(values, "", document); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	var errs []error
+	for _, s := range settings {
+		value, ok := values[s.key]
+		if !ok {
+			continue
+		}
+		delete(values, s.key)
+		if err := s.Set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, s.key, err))
+		}
+	}
+	unknown := make([]string, 0, len(values))
+	for key := range values {
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		errs = append(errs, fmt.Errorf("%s: unknown setting %s", path, key))
+	}
+	return errors.Join(errs...)
+}
+
+// flatten stores the leaves of document in values by dotted key, joining
+// lists with commas as they are written in environment variables.
+func flatten(values map[string]string, prefix string, document map[string]any) error {
+	for name, value := range document {
+		key := prefix + name
+		switch v := value.(type) {
+		case map[string]any:
+			if err := flatten(values, key+".", v); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				switch item.(type) {
+				case map[string]any, []any:
+					return fmt.Errorf("%s: lists may only hold strings and numbers", key)
+				}
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+// Print writes c to w as YAML that Load accepts, with secrets redacted.
+func (c Config) Print(w io.Writer) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := map[string]*yaml.Node{"": root}
+	for _, s := range c.settings() {
+		parent := root
+		parts := strings.Split(s.key, ".")
+		for i, part := range parts[:len(parts)-1] {
+			path := strings.Join(parts[:i+1], ".")
+			section, ok := sections[path]
+			if !ok {
+				section = &yaml.Node{Kind: yaml.MappingNode}
+				sections[path] = section
+				parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: part}, section)
+			}
+			parent = section
+		}
+		parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: parts[len(parts)-1]}, s.node())
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func (s setting) node() *yaml.Node {
+	if s.secret && !s.value.IsZero() {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "<redacted>"}
+	}
+	switch v := s.value.Interface().(type) {
+	case []string:
+		list := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, item := range v {
+			list.Content = append(list.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: item})
+		}
+		return list
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: s.String()}
+	}
+}
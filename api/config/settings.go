@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is one leaf field of a Config, addressed by its dotted key.
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// settings lists the leaf fields of c in declaration order. Setting them
+// changes c.
+func (c *Config) settings() []setting {
+	return collect(reflect.ValueOf(c).Elem(), "", "")
+}
+
+// collect lists the leaf fields of the struct v. The env tag of a section
+// prefixes the variables of its settings, so a section type can be used
+// more than once.
+func collect(v reflect.Value, prefix, envPrefix string) []setting {
+	var settings []setting
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := prefix + field.Tag.Get("key")
+		env := field.Tag.Get("env")
+		if field.Type.Kind() == reflect.Struct {
+			settings = append(settings, collect(v.Field(i), key+".", envPrefix+env)...)
+			continue
+		}
+		if env != "" {
+			env = envPrefix + env
+		}
+		settings = append(settings, setting{
+			key:    key,
+			env:    env,
+			usage:  field.Tag.Get("usage"),
+			secret: field.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return settings
+}
+
+// Set parses s as the setting's type and stores it.
+func (s setting) Set(value string) error {
+	parsed, err := s.parse(value)
+	if err != nil {
+		return err
+	}
+	s.value.Set(parsed)
+	return nil
+}
+
+// check reports whether value would be accepted by Set.
+func (s setting) check(value string) error {
+	_, err := s.parse(value)
+	return err
+}
+
+func (s setting) parse(value string) (reflect.Value, error) {
+	value = strings.TrimSpace(value)
+	switch s.value.Interface().(type) {
+	case string:
+		return reflect.ValueOf(value), nil
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not true or false", value)
+		}
+		return reflect.ValueOf(b), nil
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not an integer", value)
+		}
+		return reflect.ValueOf(n), nil
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not a duration such as 500ms or 30s", value)
+		}
+		return reflect.ValueOf(d), nil
+	case []string:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return reflect.ValueOf(list), nil
+	}
+	panic("config: unsupported type " + s.value.Type().String())
+}
+
+// String formats the setting the way Set parses it.
+func (s setting) String() string {
+	switch v := s.value.Interface().(type) {
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/dto"
+	"github.com/keanutaufan/kvstored/api/realtime"
+	"github.com/keanutaufan/kvstored/api/repository"
+	"github.com/keanutaufan/kvstored/api/service"
+)
+
+type AdminController interface {
+	AppStats(ctx *gin.Context)
+	Subscriptions(ctx *gin.Context)
+}
+
+type adminController struct {
+	keyValueService service.KeyValueService
+	socketServer    *realtime.SocketServer
+	nodeID          string
+}
+
+func NewAdminController(keyValueService service.KeyValueService, socketServer *realtime.SocketServer, nodeID string) *adminController {
+	return &adminController{
+		keyValueService: keyValueService,
+		socketServer:    socketServer,
+		nodeID:          nodeID,
+	}
+}
+
+// AppStats returns the stored size of an app and its subscribers on this
+// node. It reads every value of the app, so it requires admin permission on
+// the app.
+func (c *adminController) AppStats(ctx *gin.Context) {
+	appID := ctx.Param("app_id")
+	if auth.FromContext(ctx.Request.Context()).Permission(appID) < auth.Admin {
+		respondError(ctx, repository.NewError(auth.ErrForbidden, fmt.Sprintf("reading the stats of app %q requires admin permission", appID)))
+		return
+	}
+
+	stats, err := c.keyValueService.Stats(ctx.Request.Context(), appID)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.AppStatsResponse{
+		AppStats:    stats,
+		Subscribers: c.socketServer.Subscribers(appID),
+	})
+}
+
+// Subscriptions lists the live socket.io and WebSocket subscriptions on
+// this node, of the app in the app_id query parameter or of every app.
+// Listing every app requires admin permission on every app.
+func (c *adminController) Subscriptions(ctx *gin.Context) {
+	appID := ctx.Query("app_id")
+	scope := appID
+	if scope == "" {
+		scope = auth.AllApps
+	}
+	if auth.FromContext(ctx.Request.Context()).Permission(scope) < auth.Admin {
+		respondError(ctx, repository.NewError(auth.ErrForbidden, "listing subscriptions requires admin permission on their app, or on every app to list all of them"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.SubscriptionsResponse{
+		NodeID:        c.nodeID,
+		Subscriptions: c.socketServer.Subscriptions(appID),
+	})
+}
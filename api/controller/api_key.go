@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/dto"
+	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/repository"
+	"github.com/keanutaufan/kvstored/api/service"
+)
+
+var errCannotGrant = repository.NewError(auth.ErrForbidden, "managing this API key requires admin permission on every app in its scopes and roles")
+
+type APIKeyController interface {
+	Create(ctx *gin.Context)
+	List(ctx *gin.Context)
+	Revoke(ctx *gin.Context)
+}
+
+type apiKeyController struct {
+	apiKeyService service.APIKeyService
+	roleService   service.RoleService
+}
+
+func NewAPIKeyController(apiKeyService service.APIKeyService, roleService service.RoleService) *apiKeyController {
+	return &apiKeyController{
+		apiKeyService: apiKeyService,
+		roleService:   roleService,
+	}
+}
+
+func (c *apiKeyController) Create(ctx *gin.Context) {
+	var req dto.APIKeyCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		respondError(ctx, fmt.Errorf("%w: %v", errInvalidRequest, err))
+		return
+	}
+
+	scopes := make(map[string]auth.Permission, len(req.Scopes))
+	for appID, name := range req.Scopes {
+		permission, err := auth.ParsePermission(name)
+		if err != nil {
+			respondError(ctx, err)
+			return
+		}
+		scopes[appID] = permission
+	}
+
+	roles := make(map[string]entity.Role, len(req.Roles))
+	for _, name := range req.Roles {
+		role, err := c.roleService.Get(ctx.Request.Context(), name)
+		if errors.Is(err, service.ErrNotFound) {
+			respondError(ctx, fmt.Errorf("%w: unknown role %q", errInvalidRequest, name))
+			return
+		} else if err != nil {
+			respondError(ctx, err)
+			return
+		}
+		roles[name] = role
+	}
+
+	grants := scopesOf(entity.APIKey{Roles: req.Roles}, roles)
+	for appID, permission := range scopes {
+		grants[appID] = permission
+	}
+	if !auth.FromContext(ctx.Request.Context()).CanGrant(grants) {
+		respondError(ctx, errCannotGrant)
+		return
+	}
+
+	apiKey, key, err := c.apiKeyService.Create(ctx.Request.Context(), req.Name, scopes, req.Roles)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, dto.APIKeyCreateResponse{APIKey: apiKey, Key: key})
+}
+
+// List returns the keys the caller could revoke.
+func (c *apiKeyController) List(ctx *gin.Context) {
+	apiKeys, err := c.apiKeyService.List(ctx.Request.Context())
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	roles, err := c.roles(ctx)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	principal := auth.FromContext(ctx.Request.Context())
+	visible := []entity.APIKey{}
+	for _, apiKey := range apiKeys {
+		if principal.CanGrant(scopesOf(apiKey, roles)) {
+			visible = append(visible, apiKey)
+		}
+	}
+
+	ctx.JSON(http.StatusOK, visible)
+}
+
+func (c *apiKeyController) Revoke(ctx *gin.Context) {
+	apiKey, err := c.apiKeyService.Get(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	roles, err := c.roles(ctx)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	if !auth.FromContext(ctx.Request.Context()).CanGrant(scopesOf(apiKey, roles)) {
+		respondError(ctx, errCannotGrant)
+		return
+	}
+
+	if err := c.apiKeyService.Revoke(ctx.Request.Context(), apiKey.ID); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
+
+func (c *apiKeyController) roles(ctx *gin.Context) (map[string]entity.Role, error) {
+	roles, err := c.roleService.List(ctx.Request.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]entity.Role, len(roles))
+	for _, role := range roles {
+		byName[role.Name] = role
+	}
+	return byName, nil
+}
+
+// scopesOf returns the apps a stored key is scoped to, directly or through
+// its roles. Only the app IDs matter when deciding who may manage the key.
+// Roles that no longer exist are skipped.
+func scopesOf(apiKey entity.APIKey, roles map[string]entity.Role) map[string]auth.Permission {
+	scopes := make(map[string]auth.Permission, len(apiKey.Scopes))
+	for appID, name := range apiKey.Scopes {
+		scopes[appID], _ = auth.ParsePermission(name)
+	}
+	for _, name := range apiKey.Roles {
+		for appID, permission := range policyScopes(roles[name].Policies) {
+			scopes[appID] = max(scopes[appID], permission)
+		}
+	}
+	return scopes
+}
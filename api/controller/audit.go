@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/repository"
+	"github.com/keanutaufan/kvstored/api/service"
+)
+
+// defaultAuditWindow is how far back the audit log is searched when the
+// query sets no start time.
+const defaultAuditWindow = 24 * time.Hour
+
+type AuditController interface {
+	List(ctx *gin.Context)
+}
+
+type auditController struct {
+	auditService service.AuditService
+}
+
+func NewAuditController(auditService service.AuditService) *auditController {
+	return &auditController{auditService: auditService}
+}
+
+// List returns the audit records of an app, filtered by the from and to
+// RFC 3339 times, key and limit query parameters. Reading the audit log
+// requires admin permission on the app.
+func (c *auditController) List(ctx *gin.Context) {
+	appID := ctx.Param("app_id")
+
+	principal := auth.FromContext(ctx.Request.Context())
+	if principal.Permission(appID) < auth.Admin {
+		respondError(ctx, repository.NewError(auth.ErrForbidden, fmt.Sprintf("reading the audit log of app %q requires admin permission", appID)))
+		return
+	}
+
+	to := time.Now()
+	if s := ctx.Query("to"); s != "" {
+		var err error
+		if to, err = time.Parse(time.RFC3339, s); err != nil {
+			respondError(ctx, fmt.Errorf("%w: invalid to time: %v", errInvalidRequest, err))
+			return
+		}
+	}
+	from := to.Add(-defaultAuditWindow)
+	if s := ctx.Query("from"); s != "" {
+		var err error
+		if from, err = time.Parse(time.RFC3339, s); err != nil {
+			respondError(ctx, fmt.Errorf("%w: invalid from time: %v", errInvalidRequest, err))
+			return
+		}
+	}
+	limit := 0
+	if s := ctx.Query("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil {
+			respondError(ctx, fmt.Errorf("%w: invalid limit: %v", errInvalidRequest, err))
+			return
+		}
+	}
+
+	records, err := c.auditService.Query(ctx.Request.Context(), appID, ctx.Query("key"), from, to, limit)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, records)
+}
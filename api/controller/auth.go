@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/auth"
+)
+
+// Authenticate resolves the request's credential into a principal and stores
+// it in the request context, rejecting the request when that fails. Handlers
+// check the principal's permission on the app they touch.
+func Authenticate(authenticator auth.Authenticator) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal, err := authenticator.Authenticate(ctx.Request.Context(), auth.Credential(ctx.Request.Header))
+		if err != nil {
+			respondError(ctx, err)
+			return
+		}
+
+		ctx.Request = ctx.Request.WithContext(auth.NewContext(ctx.Request.Context(), principal))
+		ctx.Next()
+	}
+}
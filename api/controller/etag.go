@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errPreconditionFailed = errors.New("precondition failed")
+
+// formatETag renders a value version as a strong entity tag.
+func formatETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 36) + `"`
+}
+
+// etagMatches reports whether etag appears in an If-Match or If-None-Match
+// header value. If-None-Match uses the weak comparison, so W/ prefixes are
+// ignored; If-Match uses the strong comparison, where weak tags never match.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/health"
+)
+
+type HealthController interface {
+	Healthz(ctx *gin.Context)
+	Readyz(ctx *gin.Context)
+}
+
+type healthController struct {
+	health *health.Health
+}
+
+func NewHealthController(health *health.Health) *healthController {
+	return &healthController{health: health}
+}
+
+// Healthz reports that the process is alive. It keeps succeeding while the
+// node drains, so the node is not restarted before it finishes.
+func (c *healthController) Healthz(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": health.StatusOK})
+}
+
+// Readyz reports whether the node should receive traffic, with the result of
+// every dependency check.
+func (c *healthController) Readyz(ctx *gin.Context) {
+	report := c.health.Ready(ctx.Request.Context())
+	status := http.StatusOK
+	if report.Status != health.StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	ctx.JSON(status, report)
+}
@@ -1,13 +1,22 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/keanutaufan/kvstored/api/auth"
 	"github.com/keanutaufan/kvstored/api/dto"
 	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/ratelimit"
 	"github.com/keanutaufan/kvstored/api/realtime"
+	"github.com/keanutaufan/kvstored/api/repository"
 	"github.com/keanutaufan/kvstored/api/service"
 )
 
@@ -22,26 +31,56 @@ type KeyValueController interface {
 type keyValueController struct {
 	keyValueService service.KeyValueService
 	kafkaService    *realtime.KafkaService
+	limiter         *ratelimit.Limiter
+	auditService    service.AuditService
 }
 
-func NewKeyValueController(keyValueService service.KeyValueService, kafkaService *realtime.KafkaService) *keyValueController {
+func NewKeyValueController(keyValueService service.KeyValueService, kafkaService *realtime.KafkaService, limiter *ratelimit.Limiter, auditService service.AuditService) *keyValueController {
 	return &keyValueController{
 		keyValueService: keyValueService,
 		kafkaService:    kafkaService,
+		limiter:         limiter,
+		auditService:    auditService,
 	}
 }
 
+// rateLimit charges a request to the caller's and the app's rate limits. It
+// runs after authorization so rejected requests are not counted.
+func (c *keyValueController) rateLimit(ctx *gin.Context, appID string, class ratelimit.Class) error {
+	return c.limiter.Allow(ratelimit.Client(auth.FromContext(ctx.Request.Context()), ctx.ClientIP()), appID, class)
+}
+
 func (c *keyValueController) GetAll(ctx *gin.Context) {
 	appID := ctx.Param("app_id")
 
+	principal := auth.FromContext(ctx.Request.Context())
+	if err := principal.AuthorizeApp(appID, auth.ActionRead); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	if err := c.rateLimit(ctx, appID, ratelimit.Read); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
 	keyValues, err := c.keyValueService.GetAll(ctx.Request.Context(), appID)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(ctx, err)
 		return
 	}
 
+	// Callers whose policies cover only some keys see only those keys.
+	readable := keyValues[:0]
+	for _, keyValue := range keyValues {
+		if principal.Allows(appID, keyValue.Key, auth.ActionRead) {
+			readable = append(readable, keyValue)
+		}
+	}
+	keyValues = readable
+
 	if len(keyValues) == 0 {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "no keys found for the given app"})
+		respondError(ctx, errNoKeys)
 		return
 	}
 
@@ -49,27 +88,65 @@ func (c *keyValueController) GetAll(ctx *gin.Context) {
 }
 
 func (c *keyValueController) Set(ctx *gin.Context) {
-	var req dto.KeyValueSetRequest
+	var keyValue entity.KeyValue
+	keepsTTL := true
 
-	if err := ctx.BindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if ctx.Param("key") == "" {
+		var req dto.KeyValueSetRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			respondError(ctx, fmt.Errorf("%w: %v", errInvalidRequest, err))
+			return
+		}
+		keyValue = entity.KeyValue{
+			AppID: req.AppID,
+			Key:   req.Key,
+			Value: req.Value,
+		}
+	} else {
+		var err error
+		if keyValue, keepsTTL, err = c.bindPathKeyValue(ctx); err != nil {
+			respondError(ctx, err)
+			return
+		}
+	}
+
+	if err := auth.Authorize(ctx.Request.Context(), keyValue.AppID, keyValue.Key, auth.ActionWrite); err != nil {
+		respondError(ctx, err)
 		return
 	}
 
-	keyValue := entity.KeyValue{
-		AppID:     req.AppID,
-		Key:       req.Key,
-		Value:     req.Value,
-		CreatedAt: time.Now(),
+	if err := c.rateLimit(ctx, keyValue.AppID, ratelimit.Write); err != nil {
+		respondError(ctx, err)
+		return
 	}
 
-	if err := c.keyValueService.Set(ctx.Request.Context(), keyValue); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	keyValue.CreatedAt = time.Now()
+	keyValue.Version = keyValue.CreatedAt.UnixNano()
+
+	var previous *entity.KeyValue
+	current, err := c.ifMatch(ctx, keyValue.AppID, keyValue.Key)
+	if err == nil {
+		previous, err = c.previous(ctx, current, keyValue.AppID, keyValue.Key)
+	}
+	if err == nil {
+		if keepsTTL {
+			keepTTL(&keyValue, previous)
+		}
+		if current != nil {
+			err = c.keyValueService.CompareAndSet(ctx.Request.Context(), keyValue, current.Version)
+		} else {
+			err = c.keyValueService.Set(ctx.Request.Context(), keyValue)
+		}
+	}
+	if err != nil {
+		respondError(ctx, err)
 		return
 	}
 
-	c.kafkaService.AsyncPublishKeyChange("set", keyValue.AppID, keyValue.Key, &keyValue)
+	c.audit(ctx, "set", keyValue.AppID, keyValue.Key, service.PreviousHash(previous), service.HashValue(keyValue.Value))
+	c.kafkaService.AsyncPublishKeyChange(ctx.Request.Context(), "set", keyValue.AppID, keyValue.Key, &keyValue)
 
+	ctx.Header("ETag", formatETag(keyValue.Version))
 	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
@@ -77,9 +154,35 @@ func (c *keyValueController) Get(ctx *gin.Context) {
 	appID := ctx.Param("app_id")
 	key := ctx.Param("key")
 
+	if err := auth.Authorize(ctx.Request.Context(), appID, key, auth.ActionRead); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	if err := c.rateLimit(ctx, appID, ratelimit.Read); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
 	value, err := c.keyValueService.Get(ctx.Request.Context(), appID, key)
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		respondError(ctx, err)
+		return
+	}
+
+	etag := formatETag(value.Version)
+	ctx.Header("ETag", etag)
+	if match := ctx.GetHeader("If-None-Match"); match != "" && etagMatches(match, etag, true) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	if _, raw := ctx.GetQuery("raw"); raw {
+		contentType := value.ContentType
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+		ctx.Data(http.StatusOK, contentType, []byte(value.Value))
 		return
 	}
 
@@ -87,30 +190,65 @@ func (c *keyValueController) Get(ctx *gin.Context) {
 }
 
 func (c *keyValueController) Update(ctx *gin.Context) {
-	var req dto.KeyValueUpdateRequest
+	var keyValue entity.KeyValue
+	keepsTTL := true
+
+	if ctx.Param("key") == "" {
+		var req dto.KeyValueUpdateRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			respondError(ctx, fmt.Errorf("%w: %v", errInvalidRequest, err))
+			return
+		}
+		keyValue = entity.KeyValue{
+			AppID: req.AppID,
+			Key:   req.Key,
+			Value: req.Value,
+		}
+	} else {
+		var err error
+		if keyValue, keepsTTL, err = c.bindPathKeyValue(ctx); err != nil {
+			respondError(ctx, err)
+			return
+		}
+	}
 
-	if err := ctx.BindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := auth.Authorize(ctx.Request.Context(), keyValue.AppID, keyValue.Key, auth.ActionWrite); err != nil {
+		respondError(ctx, err)
 		return
 	}
 
-	keyValue := entity.KeyValue{
-		AppID: req.AppID,
-		Key:   req.Key,
-		Value: req.Value,
+	if err := c.rateLimit(ctx, keyValue.AppID, ratelimit.Write); err != nil {
+		respondError(ctx, err)
+		return
 	}
 
-	if err := c.keyValueService.Update(ctx.Request.Context(), keyValue); err != nil {
-		if err.Error() == "key not found for the given app" {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	keyValue.Version = time.Now().UnixNano()
+
+	var previous *entity.KeyValue
+	current, err := c.ifMatch(ctx, keyValue.AppID, keyValue.Key)
+	if err == nil {
+		previous, err = c.previous(ctx, current, keyValue.AppID, keyValue.Key)
+	}
+	if err == nil {
+		if keepsTTL {
+			keepTTL(&keyValue, previous)
+		}
+		if current != nil {
+			keyValue.CreatedAt = current.CreatedAt
+			err = c.keyValueService.CompareAndSet(ctx.Request.Context(), keyValue, current.Version)
 		} else {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			err = c.keyValueService.Update(ctx.Request.Context(), keyValue)
 		}
+	}
+	if err != nil {
+		respondError(ctx, err)
 		return
 	}
 
-	c.kafkaService.AsyncPublishKeyChange("update", keyValue.AppID, keyValue.Key, &keyValue)
+	c.audit(ctx, "update", keyValue.AppID, keyValue.Key, service.PreviousHash(previous), service.HashValue(keyValue.Value))
+	c.kafkaService.AsyncPublishKeyChange(ctx.Request.Context(), "update", keyValue.AppID, keyValue.Key, &keyValue)
 
+	ctx.Header("ETag", formatETag(keyValue.Version))
 	ctx.JSON(http.StatusOK, gin.H{"status": "updated"})
 }
 
@@ -118,12 +256,155 @@ func (c *keyValueController) Delete(ctx *gin.Context) {
 	appID := ctx.Param("app_id")
 	key := ctx.Param("key")
 
-	if err := c.keyValueService.Delete(ctx.Request.Context(), appID, key); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := auth.Authorize(ctx.Request.Context(), appID, key, auth.ActionDelete); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	if err := c.rateLimit(ctx, appID, ratelimit.Write); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	var previous *entity.KeyValue
+	current, err := c.ifMatch(ctx, appID, key)
+	if err == nil {
+		previous, err = c.previous(ctx, current, appID, key)
+	}
+	if err == nil {
+		if current != nil {
+			err = c.keyValueService.CompareAndDelete(ctx.Request.Context(), appID, key, current.Version)
+		} else {
+			err = c.keyValueService.Delete(ctx.Request.Context(), appID, key)
+		}
+	}
+	if err != nil {
+		respondError(ctx, err)
 		return
 	}
 
-	c.kafkaService.AsyncPublishKeyChange("delete", appID, key, nil)
+	c.audit(ctx, "delete", appID, key, service.PreviousHash(previous), "")
+	c.kafkaService.AsyncPublishKeyChange(ctx.Request.Context(), "delete", appID, key, nil)
 
 	ctx.JSON(http.StatusOK, gin.H{"status": "deleted"})
 }
+
+// ifMatch evaluates the request's If-Match precondition. It returns the
+// stored value the write must replace, or nil when the request carries no
+// If-Match header.
+func (c *keyValueController) ifMatch(ctx *gin.Context, appID, key string) (*entity.KeyValue, error) {
+	match := ctx.GetHeader("If-Match")
+	if match == "" {
+		return nil, nil
+	}
+
+	current, err := c.keyValueService.Get(ctx.Request.Context(), appID, key)
+	if errors.Is(err, service.ErrNotFound) {
+		return nil, errPreconditionFailed
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !etagMatches(match, formatETag(current.Version), false) {
+		return nil, errPreconditionFailed
+	}
+	return &current, nil
+}
+
+// bindPathKeyValue reads a value for the key addressed by the request path,
+// reporting whether the write keeps the expiry of the value it replaces. A
+// JSON body is decoded as {"value": "...", "content_type": "...", "ttl": n};
+// any other body is stored as the raw value together with the request's
+// Content-Type. Values are text, so raw bodies must be UTF-8 and default to
+// text/plain. The body is read no further than the app's value size limit
+// allows.
+func (c *keyValueController) bindPathKeyValue(ctx *gin.Context) (entity.KeyValue, bool, error) {
+	keyValue := entity.KeyValue{
+		AppID: ctx.Param("app_id"),
+		Key:   ctx.Param("key"),
+	}
+
+	maxValueSize := c.keyValueService.Policy(keyValue.AppID).MaxValueSize
+	isJSON := ctx.ContentType() == binding.MIMEJSON
+	if maxValueSize > 0 {
+		limit := int64(maxValueSize)
+		if isJSON {
+			// Escaping can grow a character to six bytes, plus the
+			// object around the value.
+			limit = 6*limit + 1024
+		}
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit)
+	}
+	tooLarge := func(err error) error {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return repository.NewError(service.ErrValidation, fmt.Sprintf("value cannot be larger than %d bytes", maxValueSize))
+		}
+		return fmt.Errorf("%w: %v", errInvalidRequest, err)
+	}
+
+	if isJSON {
+		var req dto.KeyValueBodyRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			return entity.KeyValue{}, false, tooLarge(err)
+		}
+		keyValue.Value = req.Value
+		keyValue.ContentType = req.ContentType
+		if req.TTL == nil {
+			return keyValue, true, nil
+		}
+		keyValue.TTL = *req.TTL
+		return keyValue, false, nil
+	}
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return entity.KeyValue{}, false, tooLarge(err)
+	}
+	if !utf8.Valid(body) {
+		return entity.KeyValue{}, false, fmt.Errorf("%w: value must be UTF-8 text, binary values are not supported", errInvalidRequest)
+	}
+
+	keyValue.Value = string(body)
+	keyValue.ContentType = ctx.GetHeader("Content-Type")
+	if keyValue.ContentType == "" {
+		keyValue.ContentType = "text/plain; charset=utf-8"
+	}
+	return keyValue, true, nil
+}
+
+// previous returns the value a write is about to replace, using current
+// when the write already fetched it, or nil when the key does not exist.
+func (c *keyValueController) previous(ctx *gin.Context, current *entity.KeyValue, appID, key string) (*entity.KeyValue, error) {
+	if current != nil {
+		return current, nil
+	}
+	return service.Previous(ctx.Request.Context(), c.keyValueService, appID, key)
+}
+
+// keepTTL carries the expiry of the value a write replaces over to a new
+// value whose request did not set one.
+func keepTTL(keyValue *entity.KeyValue, previous *entity.KeyValue) {
+	if previous != nil {
+		keyValue.TTL = previous.TTL
+	}
+}
+
+// audit records a successful write. The write has already happened, so a
+// failure to record it is logged rather than returned to the client.
+func (c *keyValueController) audit(ctx *gin.Context, action, appID, key, oldHash, newHash string) {
+	record := entity.AuditRecord{
+		AppID:     appID,
+		Key:       key,
+		Action:    action,
+		Actor:     auth.FromContext(ctx.Request.Context()).Subject,
+		IP:        ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+		OldHash:   oldHash,
+		NewHash:   newHash,
+		RequestID: requestID(ctx),
+	}
+	if _, err := c.auditService.Record(ctx.Request.Context(), record); err != nil {
+		slog.ErrorContext(ctx.Request.Context(), "Failed to record audit log", "action", action, "app_id", appID, "key", key, "error", err)
+	}
+}
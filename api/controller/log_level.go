@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/dto"
+	"github.com/keanutaufan/kvstored/api/logging"
+	"github.com/keanutaufan/kvstored/api/repository"
+)
+
+var errCannotManageLogging = repository.NewError(auth.ErrForbidden, "managing logging requires admin permission on every app")
+
+type LogLevelController interface {
+	Get(ctx *gin.Context)
+	Put(ctx *gin.Context)
+}
+
+type logLevelController struct{}
+
+func NewLogLevelController() *logLevelController {
+	return &logLevelController{}
+}
+
+func (c *logLevelController) Get(ctx *gin.Context) {
+	if auth.FromContext(ctx.Request.Context()).Permission(auth.AllApps) < auth.Admin {
+		respondError(ctx, errCannotManageLogging)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.LogLevel{Level: logging.Level.Level().String()})
+}
+
+// Put changes the level logged by this node until it restarts.
+func (c *logLevelController) Put(ctx *gin.Context) {
+	if auth.FromContext(ctx.Request.Context()).Permission(auth.AllApps) < auth.Admin {
+		respondError(ctx, errCannotManageLogging)
+		return
+	}
+
+	var req dto.LogLevel
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		respondError(ctx, fmt.Errorf("%w: %v", errInvalidRequest, err))
+		return
+	}
+	level, err := logging.ParseLevel(req.Level)
+	if err != nil {
+		respondError(ctx, fmt.Errorf("%w: %v", errInvalidRequest, err))
+		return
+	}
+
+	previous := logging.Level.Level()
+	logging.Level.Set(level)
+	slog.WarnContext(ctx.Request.Context(), "Log level changed",
+		"from", previous.String(),
+		"to", level.String(),
+		"by", auth.FromContext(ctx.Request.Context()).Subject,
+	)
+	ctx.JSON(http.StatusOK, dto.LogLevel{Level: level.String()})
+}
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Logger logs every request once it has been handled, at error level when
+// it failed on the server's side.
+func Logger() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(ctx.Request.Context(), level, "HTTP request",
+			"method", ctx.Request.Method,
+			"path", ctx.Request.URL.Path,
+			"route", ctx.FullPath(),
+			"status", status,
+			"latency_ms", time.Since(start).Milliseconds(),
+			"client_ip", ctx.ClientIP(),
+		)
+	}
+}
+
+// Recovery turns a panic in a handler into a 500 response and logs it with
+// its stack.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(ctx *gin.Context, err any) {
+		slog.ErrorContext(ctx.Request.Context(), "Panic while handling request",
+			"panic", err,
+			"stack", string(debug.Stack()),
+		)
+		respondError(ctx, errors.New("internal server error"))
+	})
+}
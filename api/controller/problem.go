@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/dto"
+	"github.com/keanutaufan/kvstored/api/ratelimit"
+	"github.com/keanutaufan/kvstored/api/repository"
+	"github.com/keanutaufan/kvstored/api/service"
+)
+
+var (
+	errInvalidRequest = errors.New("invalid request")
+	errNoKeys         = repository.NewError(service.ErrNotFound, "no keys found for the given app")
+)
+
+// problemTypes maps error kinds to their HTTP status and stable error code.
+// The first matching entry wins, so more specific errors come first.
+var problemTypes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{auth.ErrUnauthenticated, http.StatusUnauthorized, "unauthenticated"},
+	{auth.ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ratelimit.ErrRateLimited, http.StatusTooManyRequests, "rate_limited"},
+	{errPreconditionFailed, http.StatusPreconditionFailed, "precondition_failed"},
+	{service.ErrVersionMismatch, http.StatusPreconditionFailed, "precondition_failed"},
+	{errInvalidRequest, http.StatusBadRequest, "invalid_request"},
+	{service.ErrValidation, http.StatusBadRequest, "validation_failed"},
+	{service.ErrNotFound, http.StatusNotFound, "not_found"},
+	{service.ErrConflict, http.StatusConflict, "conflict"},
+	{service.ErrTimeout, http.StatusGatewayTimeout, "timeout"},
+	{service.ErrUnavailable, http.StatusServiceUnavailable, "unavailable"},
+}
+
+// respondError writes err as an application/problem+json response. Errors
+// that match none of the known kinds are reported as internal errors.
+func respondError(ctx *gin.Context, err error) {
+	status, code := http.StatusInternalServerError, "internal"
+	for _, problemType := range problemTypes {
+		if errors.Is(err, problemType.err) {
+			status, code = problemType.status, problemType.code
+			break
+		}
+	}
+
+	switch status {
+	case http.StatusServiceUnavailable:
+		ctx.Header("Retry-After", "1")
+	case http.StatusUnauthorized:
+		ctx.Header("WWW-Authenticate", `Bearer realm="kvstored"`)
+	case http.StatusTooManyRequests:
+		var rateLimitErr *ratelimit.Error
+		if errors.As(err, &rateLimitErr) {
+			// Round up, as clients retrying early would be limited again.
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(rateLimitErr.RetryAfter.Seconds()))))
+		}
+	}
+	ctx.Header("Content-Type", "application/problem+json")
+	ctx.AbortWithStatusJSON(status, dto.Problem{
+		Type:     "urn:kvstored:problem:" + code,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Code:     code,
+		Detail:   err.Error(),
+		Instance: ctx.Request.URL.Path,
+	})
+}
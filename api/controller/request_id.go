@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/logging"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+	// maxRequestIDLength bounds request IDs supplied by clients, which end
+	// up in audit records and logs.
+	maxRequestIDLength = 128
+)
+
+// RequestID tags each request with the X-Request-ID the client sent, or a
+// new random one, and echoes it in the response. The ID is also stored in
+// the request's context, which carries it into logs and Kafka messages.
+func RequestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = logging.NewRequestID()
+		}
+
+		ctx.Set(requestIDKey, id)
+		ctx.Request = ctx.Request.WithContext(logging.WithRequestID(ctx.Request.Context(), id))
+		ctx.Header(requestIDHeader, id)
+		ctx.Next()
+	}
+}
+
+// requestID returns the ID RequestID assigned to the request.
+func requestID(ctx *gin.Context) string {
+	return ctx.GetString(requestIDKey)
+}
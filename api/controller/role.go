@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/dto"
+	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/repository"
+	"github.com/keanutaufan/kvstored/api/service"
+)
+
+var errCannotManageRole = repository.NewError(auth.ErrForbidden, "managing this role requires admin permission on every app in its policies")
+
+type RoleController interface {
+	List(ctx *gin.Context)
+	Get(ctx *gin.Context)
+	Put(ctx *gin.Context)
+	Delete(ctx *gin.Context)
+}
+
+type roleController struct {
+	roleService service.RoleService
+}
+
+func NewRoleController(roleService service.RoleService) *roleController {
+	return &roleController{roleService: roleService}
+}
+
+// List returns the roles the caller could change.
+func (c *roleController) List(ctx *gin.Context) {
+	roles, err := c.roleService.List(ctx.Request.Context())
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	principal := auth.FromContext(ctx.Request.Context())
+	visible := []entity.Role{}
+	for _, role := range roles {
+		if principal.CanGrant(policyScopes(role.Policies)) {
+			visible = append(visible, role)
+		}
+	}
+
+	ctx.JSON(http.StatusOK, visible)
+}
+
+func (c *roleController) Get(ctx *gin.Context) {
+	role, err := c.roleService.Get(ctx.Request.Context(), ctx.Param("name"))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	if !auth.FromContext(ctx.Request.Context()).CanGrant(policyScopes(role.Policies)) {
+		respondError(ctx, errCannotManageRole)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, role)
+}
+
+// Put creates a role or replaces its policies. Replacing a role requires
+// admin permission on the apps of both its old and its new policies, so one
+// team cannot take over another team's role.
+func (c *roleController) Put(ctx *gin.Context) {
+	var req dto.RolePutRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		respondError(ctx, fmt.Errorf("%w: %v", errInvalidRequest, err))
+		return
+	}
+
+	principal := auth.FromContext(ctx.Request.Context())
+	if !principal.CanGrant(policyScopes(req.Policies)) {
+		respondError(ctx, errCannotManageRole)
+		return
+	}
+
+	current, err := c.roleService.Get(ctx.Request.Context(), ctx.Param("name"))
+	if err == nil && !principal.CanGrant(policyScopes(current.Policies)) {
+		respondError(ctx, errCannotManageRole)
+		return
+	} else if err != nil && !errors.Is(err, service.ErrNotFound) {
+		respondError(ctx, err)
+		return
+	}
+
+	role, err := c.roleService.Put(ctx.Request.Context(), entity.Role{
+		Name:     ctx.Param("name"),
+		Policies: req.Policies,
+	})
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, role)
+}
+
+func (c *roleController) Delete(ctx *gin.Context) {
+	role, err := c.roleService.Get(ctx.Request.Context(), ctx.Param("name"))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	if !auth.FromContext(ctx.Request.Context()).CanGrant(policyScopes(role.Policies)) {
+		respondError(ctx, errCannotManageRole)
+		return
+	}
+
+	if err := c.roleService.Delete(ctx.Request.Context(), role.Name); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// policyScopes returns the apps policies cover, with the app-wide permission
+// closest to the actions they grant.
+func policyScopes(policies []entity.Policy) map[string]auth.Permission {
+	scopes := make(map[string]auth.Permission, len(policies))
+	for _, policy := range policies {
+		permission := auth.Read
+		for _, name := range policy.Actions {
+			if action, _ := auth.ParseAction(name); action == auth.ActionWrite || action == auth.ActionDelete {
+				permission = auth.Write
+			}
+		}
+		scopes[policy.AppID] = max(scopes[policy.AppID], permission)
+	}
+	return scopes
+}
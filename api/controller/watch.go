@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/ratelimit"
+	"github.com/keanutaufan/kvstored/api/realtime"
+)
+
+type WatchController interface {
+	Watch(ctx *gin.Context)
+}
+
+type watchController struct {
+	changeLog *realtime.ChangeLog
+	limiter   *ratelimit.Limiter
+	heartbeat time.Duration
+}
+
+func NewWatchController(changeLog *realtime.ChangeLog, limiter *ratelimit.Limiter, heartbeat time.Duration) *watchController {
+	return &watchController{
+		changeLog: changeLog,
+		limiter:   limiter,
+		heartbeat: heartbeat,
+	}
+}
+
+// Watch streams the changes to the keys of an app, or to one key, as
+// server-sent events named key_set, key_updated and key_deleted, with the
+// payloads socket.io subscribers receive. Every event ID is the stream's
+// position in the change topic, and clients reconnecting with it in the
+// Last-Event-ID header, or the last_event_id query parameter, are first
+// sent the changes they missed. When those are no longer known, a reset
+// event tells the client to read the keys again.
+func (c *watchController) Watch(ctx *gin.Context) {
+	appID, key := ctx.Param("app_id"), ctx.Param("key")
+
+	principal := auth.FromContext(ctx.Request.Context())
+	var err error
+	if key != "" {
+		err = principal.Authorize(appID, key, auth.ActionSubscribe)
+	} else {
+		err = principal.AuthorizeApp(appID, auth.ActionSubscribe)
+	}
+	if err == nil {
+		err = c.limiter.Allow(ratelimit.Client(principal, ctx.ClientIP()), appID, ratelimit.Subscribe)
+	}
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	cursor := c.changeLog.Head()
+	lastEventID := ctx.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = ctx.Query("last_event_id")
+	}
+	if lastEventID != "" {
+		if cursor, err = realtime.ParseCursor(lastEventID); err != nil {
+			respondError(ctx, fmt.Errorf("%w: %v", errInvalidRequest, err))
+			return
+		}
+	}
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	// Keep proxies such as nginx from buffering the stream.
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+	lastID := cursor.String()
+	fmt.Fprintf(ctx.Writer, "id: %s\n\n", lastID)
+	ctx.Writer.Flush()
+
+	heartbeat := time.NewTicker(c.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		changes, head, complete, changed := c.changeLog.Since(cursor, appID, key)
+		if !complete {
+			lastID = head.String()
+			writeEvent(ctx.Writer, lastID, "reset", gin.H{"app_id": appID, "key": key})
+		} else {
+			for _, change := range changes {
+				cursor[change.Partition] = change.Offset
+				if key == "" && !principal.Allows(change.AppID, change.Key, auth.ActionSubscribe) {
+					continue
+				}
+				if name, payload, ok := changeEvent(change); ok {
+					lastID = cursor.String()
+					writeEvent(ctx.Writer, lastID, name, payload)
+				}
+			}
+			// Move the client past changes it was not sent, so it does not
+			// replay them after reconnecting.
+			if lastID != head.String() {
+				lastID = head.String()
+				fmt.Fprintf(ctx.Writer, "id: %s\n\n", lastID)
+			}
+		}
+		cursor = head
+		ctx.Writer.Flush()
+
+		select {
+		case <-changed:
+			if c.changeLog.Closed() {
+				return
+			}
+		case <-heartbeat.C:
+			io.WriteString(ctx.Writer, ": heartbeat\n\n")
+			ctx.Writer.Flush()
+		case <-ctx.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// changeEvent returns the name and payload of the event for a key change,
+// or false for changes without one, such as sets missing their value.
+func changeEvent(change realtime.KeyChangeMessage) (string, any, bool) {
+	switch change.Type {
+	case "set":
+		return "key_set", change.Value, change.Value != nil
+	case "update":
+		return "key_updated", change.Value, change.Value != nil
+	case "delete":
+		return "key_deleted", gin.H{"app_id": change.AppID, "key": change.Key}, true
+	}
+	return "", nil, false
+}
+
+func writeEvent(w io.Writer, id, name string, payload any) {
+	data, _ := json.Marshal(payload)
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, name, data)
+}
@@ -1,22 +1,94 @@
 package db
 
 import (
+	"context"
+	"net"
 	"time"
 
 	"github.com/gocql/gocql"
+	"github.com/keanutaufan/kvstored/api/certs"
+	"github.com/keanutaufan/kvstored/api/config"
 )
 
 type CassandraClient struct {
 	Session *gocql.Session
 }
 
-func NewCassandraClient(hosts []string) (*CassandraClient, error) {
-	cluster := gocql.NewCluster(hosts...)
-	cluster.Keyspace = "kv_store_app"
-	cluster.Consistency = gocql.Quorum
+// NewCluster returns the cluster configuration of cfg without a keyspace, so
+// it can also be used to create the keyspace.
+func NewCluster(cfg config.Cassandra) (*gocql.ClusterConfig, error) {
+	consistency, err := cfg.ParseConsistency()
+	if err != nil {
+		return nil, err
+	}
+
+	cluster := gocql.NewCluster(cfg.Hosts...)
+	cluster.Consistency = consistency
+	if cfg.Timeout > 0 {
+		cluster.Timeout = cfg.Timeout
+	}
+	if cfg.ConnectTimeout > 0 {
+		cluster.ConnectTimeout = cfg.ConnectTimeout
+	}
+	if cfg.Username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{
+			Username: cfg.Username,
+			Password: cfg.Password,
+		}
+	}
+	if cfg.TLS.Enabled {
+		store, err := certs.New(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.CAFile, cfg.TLS.ReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		cluster.HostDialer = &tlsHostDialer{
+			dialer:       &net.Dialer{Timeout: cluster.ConnectTimeout, KeepAlive: cluster.SocketKeepalive},
+			store:        store,
+			serverName:   cfg.TLS.ServerName,
+			verifyServer: !cfg.TLS.InsecureSkipVerify,
+		}
+	}
+	return cluster, nil
+}
+
+// tlsHostDialer connects to Cassandra nodes over TLS, verifying each node
+// against server_name or else the name it is known by: the configured host
+// name, or the address of nodes discovered from the cluster.
+type tlsHostDialer struct {
+	dialer       *net.Dialer
+	store        *certs.Store
+	serverName   string
+	verifyServer bool
+}
+
+func (d *tlsHostDialer) DialHost(ctx context.Context, host *gocql.HostInfo) (*gocql.DialedHost, error) {
+	conn, err := d.dialer.DialContext(ctx, "tcp", host.ConnectAddressAndPort())
+	if err != nil {
+		return nil, err
+	}
+	hostname, _, err := net.SplitHostPort(host.HostnameAndPort())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn, err = d.store.Client(ctx, conn, hostname, d.serverName, d.verifyServer)
+	if err != nil {
+		return nil, err
+	}
+	// Write coalescing cannot use writev on TLS connections.
+	return &gocql.DialedHost{Conn: conn, DisableCoalesce: true}, nil
+}
+
+func NewCassandraClient(cfg config.Cassandra) (*CassandraClient, error) {
+	cluster, err := NewCluster(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cluster.Keyspace = cfg.Keyspace
 	cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(gocql.RoundRobinHostPolicy())
 	cluster.ReconnectInterval = 1 * time.Second
 	cluster.RetryPolicy = &gocql.SimpleRetryPolicy{NumRetries: 3}
+	cluster.QueryObserver = queryObserver{}
 
 	session, err := cluster.CreateSession()
 	if err != nil {
@@ -25,3 +97,10 @@ func NewCassandraClient(hosts []string) (*CassandraClient, error) {
 
 	return &CassandraClient{Session: session}, nil
 }
+
+// Ping checks that the session can reach a node with a query against the
+// node's own system tables.
+func (c *CassandraClient) Ping(ctx context.Context) error {
+	return c.Session.Query("SELECT release_version FROM system.local").
+		Consistency(gocql.One).WithContext(ctx).Exec()
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/gocql/gocql"
+	"github.com/keanutaufan/kvstored/api/metrics"
+	"github.com/keanutaufan/kvstored/api/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryObserver records the latency and errors of every query, and a span
+// under the span of the query's context.
+type queryObserver struct{}
+
+func (queryObserver) ObserveQuery(ctx context.Context, query gocql.ObservedQuery) {
+	operation, table := describeStatement(query.Statement)
+	metrics.CassandraQueryDuration.WithLabelValues(operation, table).Observe(query.End.Sub(query.Start).Seconds())
+	if query.Err != nil {
+		metrics.CassandraQueryErrors.WithLabelValues(operation, table).Inc()
+	}
+
+	_, span := tracing.Tracer.Start(ctx, "cassandra "+operation+" "+table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(query.Start),
+		trace.WithAttributes(
+			semconv.DBSystemCassandra,
+			semconv.DBQueryText(query.Statement),
+			semconv.DBOperationName(operation),
+			semconv.DBCollectionName(table),
+			attribute.Int("db.cassandra.attempt", query.Attempt),
+		),
+	)
+	if query.Host != nil {
+		span.SetAttributes(semconv.ServerAddress(query.Host.ConnectAddress().String()))
+	}
+	if query.Err != nil {
+		span.RecordError(query.Err)
+		span.SetStatus(codes.Error, query.Err.Error())
+	}
+	span.End(trace.WithTimestamp(query.End))
+}
+
+var statementTable = regexp.MustCompile(`(?i)\b(?:FROM|INTO|UPDATE)\s+([\w.]+)`)
+
+// describeStatement returns the operation, such as SELECT, and the table of a
+// CQL statement, so labels stay few whatever the bound values are.
+func describeStatement(statement string) (string, string) {
+	operation, _, _ := strings.Cut(strings.TrimSpace(statement), " ")
+	table := "unknown"
+	if match := statementTable.FindStringSubmatch(statement); match != nil {
+		table = match[1]
+	}
+	return strings.ToUpper(operation), table
+}
@@ -0,0 +1,18 @@
+package dto
+
+import (
+	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/realtime"
+)
+
+// AppStatsResponse adds the subscribers connected to the node answering to
+// an app's stored stats.
+type AppStatsResponse struct {
+	entity.AppStats
+	Subscribers realtime.SubscriberCounts `json:"subscribers"`
+}
+
+type SubscriptionsResponse struct {
+	NodeID        string                        `json:"node_id"`
+	Subscriptions []realtime.SocketSubscription `json:"subscriptions"`
+}
@@ -0,0 +1,16 @@
+package dto
+
+import "github.com/keanutaufan/kvstored/api/entity"
+
+// APIKeyCreateRequest needs scopes, roles or both.
+type APIKeyCreateRequest struct {
+	Name   string            `json:"name" binding:"required"`
+	Scopes map[string]string `json:"scopes"`
+	Roles  []string          `json:"roles"`
+}
+
+// APIKeyCreateResponse is the only response that carries the key itself.
+type APIKeyCreateResponse struct {
+	entity.APIKey
+	Key string `json:"key"`
+}
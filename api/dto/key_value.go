@@ -16,3 +16,12 @@ type KeyValueUpdateRequest struct {
 	Key   string `json:"key" binding:"required"`
 	Value string `json:"value" binding:"required"`
 }
+
+// KeyValueBodyRequest is the JSON body of a write to a key addressed by the
+// request path. A missing TTL keeps the expiry of the value being replaced;
+// zero means the value never expires.
+type KeyValueBodyRequest struct {
+	Value       string `json:"value" binding:"required"`
+	ContentType string `json:"content_type,omitempty"`
+	TTL         *int   `json:"ttl,omitempty" binding:"omitempty,min=0"`
+}
@@ -0,0 +1,5 @@
+package dto
+
+type LogLevel struct {
+	Level string `json:"level" binding:"required"`
+}
@@ -0,0 +1,7 @@
+package dto
+
+import "github.com/keanutaufan/kvstored/api/entity"
+
+type RolePutRequest struct {
+	Policies []entity.Policy `json:"policies" binding:"required,dive"`
+}
@@ -0,0 +1,13 @@
+package entity
+
+import "time"
+
+type APIKey struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Hash      string            `json:"-"`      // hex SHA-256 of the key's secret
+	Scopes    map[string]string `json:"scopes"` // app ID or "*" -> read, write or admin
+	Roles     []string          `json:"roles,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	RevokedAt *time.Time        `json:"revoked_at,omitempty"`
+}
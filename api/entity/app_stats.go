@@ -0,0 +1,13 @@
+package entity
+
+import "time"
+
+// AppStats summarizes the keys an app stores.
+type AppStats struct {
+	AppID             string     `json:"app_id"`
+	Keys              int64      `json:"keys"`
+	TotalValueBytes   int64      `json:"total_value_bytes"`
+	LargestValueBytes int64      `json:"largest_value_bytes"`
+	LargestValueKey   string     `json:"largest_value_key,omitempty"`
+	LastWriteAt       *time.Time `json:"last_write_at,omitempty"` // nil when the app has no keys
+}
@@ -0,0 +1,18 @@
+package entity
+
+import "time"
+
+// AuditRecord is an append-only record of a write to a key.
+type AuditRecord struct {
+	ID        string    `json:"id"`
+	AppID     string    `json:"app_id"`
+	Key       string    `json:"key"`
+	Action    string    `json:"action"` // set, update or delete
+	Actor     string    `json:"actor"`  // subject of the authenticated caller
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	OldHash   string    `json:"old_hash,omitempty"` // hex SHA-256 of the value before the write, empty if there was none
+	NewHash   string    `json:"new_hash,omitempty"` // hex SHA-256 of the value written, empty for deletes
+	RequestID string    `json:"request_id"`
+	Time      time.Time `json:"time"`
+}
@@ -3,8 +3,11 @@ package entity
 import "time"
 
 type KeyValue struct {
-	AppID     string    `json:"app_id" binding:"required"`
-	Key       string    `json:"key" binding:"required"`
-	Value     string    `json:"value" binding:"required"`
-	CreatedAt time.Time `json:"created_at"`
+	AppID       string    `json:"app_id" binding:"required"`
+	Key         string    `json:"key" binding:"required"`
+	Value       string    `json:"value" binding:"required"`
+	ContentType string    `json:"content_type,omitempty"`
+	Version     int64     `json:"version"`
+	TTL         int       `json:"ttl,omitempty"` // seconds until expiry, 0 means never
+	CreatedAt   time.Time `json:"created_at"`
 }
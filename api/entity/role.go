@@ -0,0 +1,18 @@
+package entity
+
+import "time"
+
+// Role is a named set of policies that API keys and tokens can be assigned.
+type Role struct {
+	Name      string    `json:"name"`
+	Policies  []Policy  `json:"policies"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Policy grants actions on the keys of an app, or of every app when AppID is
+// "*", that match Key: an exact key, or a prefix followed by "*".
+type Policy struct {
+	AppID   string   `json:"app_id" binding:"required"`
+	Key     string   `json:"key" binding:"required"`
+	Actions []string `json:"actions" binding:"required"` // read, write, delete or subscribe
+}
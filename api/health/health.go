@@ -0,0 +1,107 @@
+// Package health reports whether the node can serve traffic.
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses of a readiness report and of its checks.
+const (
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusDraining = "draining"
+)
+
+// Checker checks one dependency, returning an error when it is unusable.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a function to a Checker.
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+type Result struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Health runs the registered checks to decide whether the node is ready.
+type Health struct {
+	checks   map[string]Checker
+	timeout  time.Duration
+	draining atomic.Bool
+}
+
+// New returns a Health whose checks each get timeout to complete.
+func New(timeout time.Duration) *Health {
+	return &Health{
+		checks:  make(map[string]Checker),
+		timeout: timeout,
+	}
+}
+
+// Register adds a check. It must be called before Ready is.
+func (h *Health) Register(name string, checker Checker) {
+	h.checks[name] = checker
+}
+
+// Drain marks the node as shutting down, so it reports not ready while it
+// finishes the requests it already accepted.
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+func (h *Health) Draining() bool {
+	return h.draining.Load()
+}
+
+// Ready runs every check concurrently. The report's status is ok only when
+// every check passed and the node is not draining.
+func (h *Health) Ready(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(h.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, checker := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			err := checker.Check(ctx)
+			result := Result{Status: StatusOK, LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = StatusFail
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = StatusFail
+			}
+		}()
+	}
+	wg.Wait()
+
+	if h.Draining() {
+		report.Status = StatusDraining
+	}
+	return report
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/keanutaufan/kvstored/api/entity"
+)
+
+func runGet(ctx context.Context, cli *cli, args []string) error {
+	keyValue, err := cli.client.Get(ctx, cli.appID, args[0])
+	if err != nil {
+		return err
+	}
+	return cli.output.keyValue(keyValue)
+}
+
+func runSet(ctx context.Context, cli *cli, args []string) error {
+	value, err := readValue(args[1])
+	if err != nil {
+		return err
+	}
+	return cli.client.Set(ctx, cli.appID, args[0], value)
+}
+
+func runUpdate(ctx context.Context, cli *cli, args []string) error {
+	value, err := readValue(args[1])
+	if err != nil {
+		return err
+	}
+	return cli.client.Update(ctx, cli.appID, args[0], value)
+}
+
+func runDelete(ctx context.Context, cli *cli, args []string) error {
+	return cli.client.Delete(ctx, cli.appID, args[0])
+}
+
+func runList(ctx context.Context, cli *cli, args []string) error {
+	keyValues, err := cli.client.GetAll(ctx, cli.appID)
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		filtered := keyValues[:0]
+		for _, keyValue := range keyValues {
+			if strings.HasPrefix(keyValue.Key, args[0]) {
+				filtered = append(filtered, keyValue)
+			}
+		}
+		keyValues = filtered
+	}
+	sort.Slice(keyValues, func(i, j int) bool {
+		return keyValues[i].Key < keyValues[j].Key
+	})
+	return cli.output.keyValues(keyValues)
+}
+
+// runWatch prints changes until interrupted.
+func runWatch(ctx context.Context, cli *cli, args []string) error {
+	var key string
+	if len(args) > 0 {
+		key = args[0]
+	}
+
+	events, err := cli.client.Watch(ctx, cli.appID, key)
+	if err != nil {
+		return err
+	}
+	for event := range events {
+		if err := cli.output.event(event); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func runExport(ctx context.Context, cli *cli, args []string) (err error) {
+	keyValues, err := cli.client.GetAll(ctx, cli.appID)
+	if err != nil {
+		return err
+	}
+	sort.Slice(keyValues, func(i, j int) bool {
+		return keyValues[i].Key < keyValues[j].Key
+	})
+
+	w := io.Writer(os.Stdout)
+	if len(args) > 0 && args[0] != "-" {
+		var f *os.File
+		if f, err = os.Create(args[0]); err != nil {
+			return err
+		}
+		// A failed close can lose buffered writes, so it fails the export.
+		defer func() {
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		w = f
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(keyValues)
+}
+
+// runImport sets the values of an export into the selected app, which need
+// not be the app the export was taken from. Values keep their content type
+// and the TTL they had left when exported; versions and creation times are
+// assigned anew.
+func runImport(ctx context.Context, cli *cli, args []string) error {
+	r := io.Reader(os.Stdin)
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var keyValues []entity.KeyValue
+	if err := json.NewDecoder(r).Decode(&keyValues); err != nil {
+		return fmt.Errorf("reading export: %w", err)
+	}
+
+	for i, keyValue := range keyValues {
+		keyValue.AppID = cli.appID
+		if err := cli.client.SetKeyValue(ctx, keyValue); err != nil {
+			return fmt.Errorf("importing %q (%d of %d imported): %w", keyValue.Key, i, len(keyValues), err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "imported %d keys\n", len(keyValues))
+	return nil
+}
+
+// readValue returns arg, or the whole of stdin when arg is "-".
+func readValue(arg string) (string, error) {
+	if arg != "-" {
+		return arg, nil
+	}
+	value, err := io.ReadAll(os.Stdin)
+	return string(value), err
+}
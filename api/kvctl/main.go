@@ -0,0 +1,117 @@
+// Command kvctl manages the values of a kvstored app from the terminal.
+//
+//	kvctl [flags] <command> [arguments]
+//
+// Run kvctl -h for the list of commands and flags.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/keanutaufan/kvstored/api/client"
+	"github.com/keanutaufan/kvstored/api/utils"
+)
+
+const usage = `Usage: kvctl [flags] <command> [arguments]
+
+Commands:
+  get <key>              print a value
+  set <key> <value>      create or overwrite a value ("-" reads it from stdin)
+  update <key> <value>   overwrite an existing value ("-" reads it from stdin)
+  delete <key>           delete a value
+  ls [prefix]            list the app's values, optionally only keys with prefix
+  watch [key]            stream changes to a key, or to the whole app
+  export [file]          write the app's values as JSON to file or stdout
+  import [file]          set every value of an export read from file or stdin
+
+Flags:
+`
+
+type command struct {
+	run     func(ctx context.Context, cli *cli, args []string) error
+	minArgs int
+	maxArgs int
+}
+
+var commands = map[string]command{
+	"get":    {run: runGet, minArgs: 1, maxArgs: 1},
+	"set":    {run: runSet, minArgs: 2, maxArgs: 2},
+	"update": {run: runUpdate, minArgs: 2, maxArgs: 2},
+	"delete": {run: runDelete, minArgs: 1, maxArgs: 1},
+	"ls":     {run: runList, minArgs: 0, maxArgs: 1},
+	"watch":  {run: runWatch, minArgs: 0, maxArgs: 1},
+	"export": {run: runExport, minArgs: 0, maxArgs: 1},
+	"import": {run: runImport, minArgs: 0, maxArgs: 1},
+}
+
+type cli struct {
+	client *client.Client
+	appID  string
+	output output
+}
+
+func main() {
+	flags := flag.NewFlagSet("kvctl", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	endpoints := flags.String("endpoints", utils.LoadEnv("KVSTORED_ENDPOINTS", "http://localhost:8000"), "comma separated server URLs (env KVSTORED_ENDPOINTS)")
+	appID := flags.String("app", os.Getenv("KVSTORED_APP"), "app ID (env KVSTORED_APP)")
+	apiKey := flags.String("api-key", os.Getenv("KVSTORED_API_KEY"), "API key (env KVSTORED_API_KEY)")
+	format := flags.String("o", "table", "output format: table, json or raw")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of each request")
+	flags.Parse(os.Args[1:])
+
+	args := flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "kvctl: unknown command %q\n", args[0])
+		os.Exit(2)
+	}
+	if len(args)-1 < cmd.minArgs || len(args)-1 > cmd.maxArgs {
+		fmt.Fprintf(os.Stderr, "kvctl: wrong number of arguments for %s\n", args[0])
+		os.Exit(2)
+	}
+	if *appID == "" {
+		fmt.Fprintln(os.Stderr, "kvctl: an app ID is required, set -app or KVSTORED_APP")
+		os.Exit(2)
+	}
+
+	out, err := newOutput(*format, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kvctl:", err)
+		os.Exit(2)
+	}
+
+	kvClient, err := client.New(strings.Split(*endpoints, ","),
+		client.WithHTTPClient(&http.Client{Timeout: *timeout}),
+		client.WithAPIKey(*apiKey),
+	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kvctl:", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = cmd.run(ctx, &cli{client: kvClient, appID: *appID, output: out}, args[1:])
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "kvctl:", err)
+		stop()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/keanutaufan/kvstored/api/client"
+	"github.com/keanutaufan/kvstored/api/entity"
+)
+
+// maxCellWidth truncates long values in table output.
+const maxCellWidth = 60
+
+type output interface {
+	keyValue(keyValue entity.KeyValue) error
+	keyValues(keyValues []entity.KeyValue) error
+	event(event client.Event) error
+}
+
+func newOutput(format string, w io.Writer) (output, error) {
+	switch format {
+	case "table":
+		return tableOutput{w: w}, nil
+	case "json":
+		return jsonOutput{w: w}, nil
+	case "raw":
+		return rawOutput{w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+type tableOutput struct {
+	w io.Writer
+}
+
+func (o tableOutput) keyValue(keyValue entity.KeyValue) error {
+	return o.keyValues([]entity.KeyValue{keyValue})
+}
+
+func (o tableOutput) keyValues(keyValues []entity.KeyValue) error {
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tVERSION\tTTL\tCREATED")
+	for _, keyValue := range keyValues {
+		ttl := "-"
+		if keyValue.TTL > 0 {
+			ttl = (time.Duration(keyValue.TTL) * time.Second).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n",
+			keyValue.Key, cell(keyValue.Value), keyValue.Version, ttl, keyValue.CreatedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func (o tableOutput) event(event client.Event) error {
+	value := ""
+	if event.Value != nil {
+		value = cell(event.Value.Value)
+	}
+	_, err := fmt.Fprintf(o.w, "%s  %-6s  %s  %s\n", time.Now().Format(time.TimeOnly), event.Type, event.Key, value)
+	return err
+}
+
+// cell quotes values that would break the table layout and shortens long
+// ones.
+func cell(value string) string {
+	if strings.ContainsAny(value, "\t\r\n") {
+		value = strconv.Quote(value)
+	}
+	if runes := []rune(value); len(runes) > maxCellWidth {
+		value = string(runes[:maxCellWidth-3]) + "..."
+	}
+	return value
+}
+
+// jsonOutput writes lists as a JSON array and events as one JSON object per
+// line, so watch output can be piped into jq.
+type jsonOutput struct {
+	w io.Writer
+}
+
+func (o jsonOutput) keyValue(keyValue entity.KeyValue) error {
+	return o.encode(keyValue)
+}
+
+func (o jsonOutput) keyValues(keyValues []entity.KeyValue) error {
+	return o.encode(keyValues)
+}
+
+func (o jsonOutput) encode(v any) error {
+	encoder := json.NewEncoder(o.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (o jsonOutput) event(event client.Event) error {
+	return json.NewEncoder(o.w).Encode(struct {
+		Type  client.EventType `json:"type"`
+		AppID string           `json:"app_id"`
+		Key   string           `json:"key"`
+		Value *entity.KeyValue `json:"value,omitempty"`
+	}{event.Type, event.AppID, event.Key, event.Value})
+}
+
+// rawOutput writes bare values, one per line. A single value is written
+// verbatim so it round trips through files unchanged.
+type rawOutput struct {
+	w io.Writer
+}
+
+func (o rawOutput) keyValue(keyValue entity.KeyValue) error {
+	_, err := io.WriteString(o.w, keyValue.Value)
+	return err
+}
+
+func (o rawOutput) keyValues(keyValues []entity.KeyValue) error {
+	for _, keyValue := range keyValues {
+		if _, err := fmt.Fprintln(o.w, keyValue.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o rawOutput) event(event client.Event) error {
+	if event.Value == nil {
+		return nil
+	}
+	_, err := fmt.Fprintln(o.w, event.Value.Value)
+	return err
+}
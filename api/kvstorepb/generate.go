@@ -0,0 +1,5 @@
+// Package kvstorepb contains the protobuf messages and gRPC stubs generated
+// from kvstore.proto.
+package kvstorepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative kvstore.proto
@@ -1,59 +1,297 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/certs"
+	"github.com/keanutaufan/kvstored/api/config"
 	"github.com/keanutaufan/kvstored/api/controller"
 	"github.com/keanutaufan/kvstored/api/db"
+	"github.com/keanutaufan/kvstored/api/health"
+	"github.com/keanutaufan/kvstored/api/kvstorepb"
+	"github.com/keanutaufan/kvstored/api/logging"
+	"github.com/keanutaufan/kvstored/api/memcache"
+	"github.com/keanutaufan/kvstored/api/ratelimit"
 	"github.com/keanutaufan/kvstored/api/realtime"
 	"github.com/keanutaufan/kvstored/api/repository"
+	"github.com/keanutaufan/kvstored/api/resp"
 	"github.com/keanutaufan/kvstored/api/routes"
+	"github.com/keanutaufan/kvstored/api/rpc"
 	"github.com/keanutaufan/kvstored/api/service"
-	"github.com/keanutaufan/kvstored/api/utils"
+	"github.com/keanutaufan/kvstored/api/tracing"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	if os.Getenv("APP_ENV") != "production" {
-		err := godotenv.Load(".env")
+	printConfig := flag.Bool("print-config", false, "print the configuration, with secrets redacted, and exit")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if *printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
+		}
+		return
+	}
+
+	if err := logging.Setup(cfg.Log.Format, cfg.Log.Level); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	if cfg.Tracing.Enabled {
+		shutdownTracing, err := tracing.Setup(context.Background(), cfg.NodeID, cfg.Tracing.Endpoint)
 		if err != nil {
-			panic(err)
+			log.Fatalf("Failed to set up tracing: %v", err)
 		}
+		defer shutdownTracing(context.Background())
 	}
 
-	cqlHosts := utils.LoadEnv("CASSANDRA_HOSTS", "localhost")
-	cassandraClient, err := db.NewCassandraClient(strings.Split(cqlHosts, ","))
+	cassandraClient, err := db.NewCassandraClient(cfg.Cassandra)
 	if err != nil {
 		log.Fatalf("Failed to create Cassandra client: %v", err)
 	}
 
 	keyValueRepository := repository.NewKeyValueRepository(cassandraClient)
-	keyValueService := service.NewKeyValueService(keyValueRepository)
+	validationPolicies := service.DefaultValidationPolicies()
+	if path := cfg.Validation.PolicyFile; path != "" {
+		if validationPolicies, err = service.LoadValidationPolicies(path); err != nil {
+			log.Fatalf("Failed to load validation policies: %v", err)
+		}
+	}
+	keyValueService := service.TraceKeyValueService(service.NewKeyValueService(keyValueRepository, validationPolicies))
 
 	defer cassandraClient.Session.Close()
 
-	nodeId := utils.LoadEnv("NODE_ID", "kvstored1")
-	kafkaHosts := utils.LoadEnv("KAFKA_HOSTS", "localhost")
-	kafkaService := realtime.NewKafkaService(strings.Split(kafkaHosts, ","), "kvstored-group-"+nodeId)
-	defer kafkaService.Close()
+	apiKeyRepository := repository.NewAPIKeyRepository(cassandraClient)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepository, cfg.Auth.AdminAPIKey)
+
+	auditRepository := repository.NewAuditRepository(cassandraClient)
+	auditService := service.NewAuditService(auditRepository)
+
+	roleRepository := repository.NewRoleRepository(cassandraClient)
+	roleService := service.NewRoleService(roleRepository)
+
+	authenticators := []auth.Authenticator{apiKeyService}
+	if jwt := cfg.Auth.JWT; jwt.JWKS != "" {
+		jwtAuthenticator, err := auth.NewJWTAuthenticator(auth.JWTConfig{
+			JWKS:        jwt.JWKS,
+			Issuer:      jwt.Issuer,
+			Audience:    jwt.Audience,
+			ScopesClaim: jwt.ScopesClaim,
+			RolesClaim:  jwt.RolesClaim,
+			Leeway:      jwt.Leeway,
+		})
+		if err != nil {
+			log.Fatalf("Failed to set up JWT authentication: %v", err)
+		}
+		authenticators = append(authenticators, jwtAuthenticator)
+	}
+
+	authenticator := auth.WithRoles(auth.Chain(authenticators...), roleService)
+	if !cfg.Auth.Enabled {
+		slog.Warn("Authentication is disabled, every caller has admin permission on every app")
+		authenticator = auth.AllowAll()
+	}
+
+	var limiter *ratelimit.Limiter
+	if cfg.RateLimit.Enabled {
+		rules := ratelimit.DefaultRules()
+		if err := rules.Parse(cfg.RateLimit.Rules); err != nil {
+			log.Fatalf("Invalid configuration:\nrate_limit.rules: %v", err)
+		}
+
+		var store ratelimit.Store = ratelimit.NewLocalStore()
+		if cfg.RateLimit.Shared {
+			sharedStore := ratelimit.NewSharedStore(repository.NewRateLimitRepository(cassandraClient))
+			defer sharedStore.Close()
+			store = sharedStore
+		}
+		limiter = ratelimit.New(rules, store)
+	}
+
+	kafkaService, err := realtime.NewKafkaService(cfg.Kafka)
+	if err != nil {
+		log.Fatalf("Failed to create Kafka client: %v", err)
+	}
+
+	socketServer := realtime.NewSocketServer(authenticator, limiter, cfg.Socket)
+	go socketServer.Serve()
+
+	broker := realtime.NewBroker()
+
+	changeLog := realtime.NewChangeLog(cfg.Watch.ReplayBuffer)
+
+	go kafkaService.StartConsumer(socketServer, broker, changeLog)
+
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(rpc.UnaryInterceptor(authenticator)),
+		grpc.StreamInterceptor(rpc.StreamInterceptor(authenticator)),
+	)
+	kvstorepb.RegisterKeyValueServiceServer(grpcServer, rpc.NewKeyValueServer(keyValueService, kafkaService, broker, limiter, auditService))
 
-	socketServer := realtime.NewSocketServer()
-	go socketServer.Server.Serve()
-	defer socketServer.Server.Close()
+	grpcListener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPC.Port))
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
+	}
+	go grpcServer.Serve(grpcListener)
+
+	respServer := resp.NewServer(keyValueService, kafkaService, broker, authenticator, limiter, auditService)
+
+	respListener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.RESP.Port))
+	if err != nil {
+		log.Fatalf("Failed to listen for RESP: %v", err)
+	}
+	go respServer.Serve(respListener)
 
-	go kafkaService.StartConsumer(socketServer)
+	memcacheServer := memcache.NewServer(keyValueService, kafkaService, cfg.Memcached.AppID, authenticator, limiter, auditService)
 
-	keyValueController := controller.NewKeyValueController(keyValueService, kafkaService)
+	memcacheListener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Memcached.Port))
+	if err != nil {
+		log.Fatalf("Failed to listen for memcached: %v", err)
+	}
+	go memcacheServer.Serve(memcacheListener)
 
-	server := gin.Default()
-	routes.KeyValueRoutes(server, keyValueController)
+	healthChecks := health.New(2 * time.Second)
+	healthChecks.Register("cassandra", health.CheckerFunc(cassandraClient.Ping))
+	healthChecks.Register("kafka_writer", health.CheckerFunc(kafkaService.CheckWriter))
+	healthChecks.Register("kafka_reader", health.CheckerFunc(kafkaService.CheckReader))
+	healthChecks.Register("socket_server", socketServer)
 
+	keyValueController := controller.NewKeyValueController(keyValueService, kafkaService, limiter, auditService)
+	apiKeyController := controller.NewAPIKeyController(apiKeyService, roleService)
+	roleController := controller.NewRoleController(roleService)
+	auditController := controller.NewAuditController(auditService)
+	healthController := controller.NewHealthController(healthChecks)
+	logLevelController := controller.NewLogLevelController()
+	adminController := controller.NewAdminController(keyValueService, socketServer, cfg.NodeID)
+	watchController := controller.NewWatchController(changeLog, limiter, cfg.Watch.HeartbeatInterval)
+
+	server := gin.New()
+	// Route on the escaped path so keys such as feature/x can be addressed
+	// as /kv/app/feature%2Fx.
+	server.UseRawPath = true
+	// Client IPs, recorded in the audit log and charged for rate limits, are
+	// only taken from X-Forwarded-For when it was set by a trusted proxy.
+	if err := server.SetTrustedProxies(cfg.HTTP.TrustedProxies); err != nil {
+		log.Fatalf("Invalid configuration:\nhttp.trusted_proxies: %v", err)
+	}
+	server.Use(otelgin.Middleware(tracing.ServiceName, otelgin.WithFilter(traceRequest)))
+	server.Use(controller.RequestID(), controller.Logger(), controller.Recovery(), controller.Metrics())
+	routes.HealthRoutes(server, healthController)
+	routes.KeyValueRoutes(server, keyValueController, controller.Authenticate(authenticator))
+	routes.APIKeyRoutes(server, apiKeyController, controller.Authenticate(authenticator))
+	routes.RoleRoutes(server, roleController, controller.Authenticate(authenticator))
+	routes.AuditRoutes(server, auditController, controller.Authenticate(authenticator))
+	routes.LogLevelRoutes(server, logLevelController, controller.Authenticate(authenticator))
+	routes.AdminRoutes(server, adminController, controller.Authenticate(authenticator))
+	routes.WatchRoutes(server, watchController, controller.Authenticate(authenticator))
+
+	server.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	server.GET("/socket.io/*any", gin.WrapH(socketServer.Server))
 	server.POST("/socket.io/*any", gin.WrapH(socketServer.Server))
+	server.GET("/ws", gin.WrapF(socketServer.ServeWebSocket))
+
+	httpServer := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.HTTP.Port),
+		Handler:           server,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+	}
+	if tlsConfig := cfg.HTTP.TLS; tlsConfig.Enabled {
+		store, err := certs.New(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.ClientCAFile, tlsConfig.ReloadInterval)
+		if err != nil {
+			log.Fatalf("Failed to load HTTP TLS certificates: %v", err)
+		}
+		clientAuth, _ := tlsConfig.ClientAuthType()
+		httpServer.TLSConfig = store.ServerConfig(clientAuth)
+	}
+	go func() {
+		var err error
+		if httpServer.TLSConfig != nil {
+			// The certificate comes from TLSConfig.
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to serve HTTP: %v", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	signal.Stop(signals)
+
+	// Fail readiness first and give load balancers time to stop routing to
+	// this node, then stop within the timeout.
+	slog.Info("Draining before shutting down", "delay", cfg.Shutdown.DrainDelay.String(), "timeout", cfg.Shutdown.Timeout.String())
+	healthChecks.Drain()
+	time.Sleep(cfg.Shutdown.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+
+	// Socket.io clients reconnect elsewhere while the node stops.
+	socketsClosed := make(chan struct{})
+	go func() {
+		defer close(socketsClosed)
+		if err := socketServer.Shutdown(ctx); err != nil {
+			slog.Error("Failed to close socket.io server", "error", err)
+		}
+	}()
 
-	port := utils.LoadEnv("PORT", "8000")
-	server.Run(":" + port)
+	// Stop accepting requests and finish the ones in flight. Closing the
+	// broker and the change log ends Watch streams, RESP subscriptions and
+	// server-sent event streams, which would otherwise keep their servers
+	// open.
+	broker.Close()
+	changeLog.Close()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		slog.Error("Failed to finish HTTP requests", "error", err)
+	}
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		grpcServer.Stop()
+	}
+	respServer.Close()
+	memcacheServer.Close()
+
+	// Every server has waited for its in-flight writes by now, so waiting
+	// for pending publishes delivers all their change events.
+	if err := kafkaService.Shutdown(ctx); err != nil {
+		slog.Error("Failed to close Kafka connections", "error", err)
+	}
+	<-socketsClosed
+	slog.Info("Shut down")
+}
+
+// traceRequest leaves probes and scrapes out of traces.
+func traceRequest(req *http.Request) bool {
+	switch req.URL.Path {
+	case "/healthz", "/readyz", "/metrics":
+		return false
+	}
+	return true
 }
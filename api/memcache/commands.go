@@ -0,0 +1,493 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/ratelimit"
+	"github.com/keanutaufan/kvstored/api/service"
+)
+
+// contentType marks values written through memcached. Client flags are kept
+// as a media type parameter, and values that are not valid UTF-8 are stored
+// base64 encoded.
+const contentType = "application/vnd.memcached"
+
+// relativeExptimeLimit is the largest exptime memcached treats as a number of
+// seconds; larger values are absolute Unix timestamps.
+const relativeExptimeLimit = 60 * 60 * 24 * 30
+
+// touchAttempts bounds how often touch retries when the value changes while
+// its TTL is being rewritten.
+const touchAttempts = 3
+
+type session struct {
+	server *Server
+	ctx    context.Context
+	reader *bufio.Reader
+	writer *bufio.Writer
+
+	remoteAddr string
+	principal  *auth.Principal // nil until the connection authenticates
+}
+
+func (s *session) reply(line string) {
+	s.writer.WriteString(line + "\r\n")
+}
+
+// dispatch runs one command and reports whether the connection should close.
+func (s *session) dispatch(fields []string) bool {
+	if s.principal == nil {
+		switch fields[0] {
+		case "set":
+			s.authenticate(fields)
+		case "quit":
+			return true
+		default:
+			s.reply("CLIENT_ERROR unauthenticated")
+		}
+		return false
+	}
+
+	switch fields[0] {
+	case "get":
+		s.get(fields, false)
+	case "gets":
+		s.get(fields, true)
+	case "set", "add", "replace", "cas":
+		s.store(fields)
+	case "delete":
+		s.delete(fields)
+	case "touch":
+		s.touch(fields)
+	case "version":
+		s.reply("VERSION kvstored")
+	case "quit":
+		return true
+	default:
+		s.reply("ERROR")
+	}
+	return false
+}
+
+// authenticate implements memcached's ASCII authentication:
+//
+//	set <key> <flags> <exptime> <bytes>
+//	<username> <credential>
+//
+// Only the credential is checked.
+func (s *session) authenticate(fields []string) {
+	if len(fields) < 5 {
+		s.reply("ERROR")
+		return
+	}
+	size, err := strconv.Atoi(fields[4])
+	if err != nil || size < 0 || size > maxItemSize {
+		s.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(s.reader, data); err != nil {
+		return
+	}
+
+	_, credential, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	principal, err := s.server.authenticator.Authenticate(s.ctx, credential)
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		s.reply("CLIENT_ERROR authentication failure")
+		return
+	} else if err != nil {
+		s.serverError(err)
+		return
+	}
+	s.principal = principal
+	s.reply("STORED")
+}
+
+// maxValueSize is the largest data block a storage command may carry: the
+// app's value size limit, capped at memcached's item size limit.
+func (s *session) maxValueSize() int {
+	limit := s.server.keyValueService.Policy(s.server.appID).MaxValueSize
+	if limit <= 0 || limit > maxItemSize {
+		return maxItemSize
+	}
+	return limit
+}
+
+// authorize checks that the session may perform action on keys and charges
+// the command to the rate limits of class. It reports the error to the
+// client and returns false when the command may not run.
+func (s *session) authorize(action auth.Action, class ratelimit.Class, keys ...string) bool {
+	appID := s.server.appID
+	var err error
+	for _, key := range keys {
+		if err = s.principal.Authorize(appID, key, action); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = s.server.limiter.Allow(ratelimit.Client(s.principal, s.remoteAddr), appID, class)
+	}
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, auth.ErrForbidden), errors.Is(err, ratelimit.ErrRateLimited):
+		s.reply("CLIENT_ERROR " + err.Error())
+	default:
+		s.serverError(err)
+	}
+	return false
+}
+
+// audit records a successful write. The write has already happened, so a
+// failure to record it is logged rather than returned to the client.
+func (s *session) audit(action, key, oldHash, newHash string) {
+	ip := s.remoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	record := entity.AuditRecord{
+		AppID:     s.server.appID,
+		Key:       key,
+		Action:    action,
+		Actor:     s.principal.Subject,
+		IP:        ip,
+		UserAgent: "memcached",
+		OldHash:   oldHash,
+		NewHash:   newHash,
+	}
+	if _, err := s.server.auditService.Record(s.ctx, record); err != nil {
+		slog.ErrorContext(s.ctx, "Failed to record audit log", "action", action, "app_id", s.server.appID, "key", key, "error", err)
+	}
+}
+
+func (s *session) get(fields []string, withCAS bool) {
+	if len(fields) < 2 {
+		s.reply("ERROR")
+		return
+	}
+	if !s.authorize(auth.ActionRead, ratelimit.Read, fields[1:]...) {
+		return
+	}
+
+	for _, key := range fields[1:] {
+		keyValue, err := s.server.keyValueService.Get(s.ctx, s.server.appID, key)
+		if errors.Is(err, service.ErrNotFound) {
+			continue
+		} else if err != nil {
+			s.serverError(err)
+			return
+		}
+
+		flags, data, err := decodeValue(keyValue)
+		if err != nil {
+			s.serverError(err)
+			return
+		}
+
+		if withCAS {
+			s.reply(fmt.Sprintf("VALUE %s %d %d %d", key, flags, len(data), uint64(keyValue.Version)))
+		} else {
+			s.reply(fmt.Sprintf("VALUE %s %d %d", key, flags, len(data)))
+		}
+		s.writer.Write(data)
+		s.reply("")
+	}
+	s.reply("END")
+}
+
+// store implements set, add, replace and cas:
+//
+//	<command> <key> <flags> <exptime> <bytes> [noreply]
+//	cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func (s *session) store(fields []string) {
+	command := fields[0]
+	argc := 5
+	if command == "cas" {
+		argc = 6
+	}
+	if len(fields) < argc || len(fields) > argc+1 {
+		s.reply("ERROR")
+		return
+	}
+	noreply := len(fields) == argc+1 && fields[argc] == "noreply"
+
+	key := fields[1]
+	flags, err1 := strconv.ParseUint(fields[2], 10, 32)
+	exptime, err2 := strconv.ParseInt(fields[3], 10, 64)
+	size, err3 := strconv.Atoi(fields[4])
+	var casUnique uint64
+	var err4 error
+	if command == "cas" {
+		casUnique, err4 = strconv.ParseUint(fields[5], 10, 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || size < 0 || !validKey(key) {
+		s.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+
+	if size > s.maxValueSize() {
+		// Swallow the data block so the connection stays usable.
+		io.CopyN(io.Discard, s.reader, int64(size)+2)
+		s.reply("SERVER_ERROR object too large for cache")
+		return
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(s.reader, data); err != nil {
+		return
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		s.reply("CLIENT_ERROR bad data chunk")
+		return
+	}
+	data = data[:size]
+
+	if !s.authorize(auth.ActionWrite, ratelimit.Write, key) {
+		return
+	}
+	previous, err := service.Previous(s.ctx, s.server.keyValueService, s.server.appID, key)
+	if err != nil {
+		s.serverError(err)
+		return
+	}
+
+	ttl, expired := ttlFromExptime(exptime)
+	now := time.Now()
+	keyValue := encodeValue(uint32(flags), data)
+	keyValue.AppID = s.server.appID
+	keyValue.Key = key
+	keyValue.TTL = ttl
+	keyValue.Version = now.UnixNano()
+	keyValue.CreatedAt = now
+
+	changeType := "set"
+	switch command {
+	case "set":
+		err = s.server.keyValueService.Set(s.ctx, keyValue)
+	case "add":
+		err = s.server.keyValueService.Create(s.ctx, keyValue)
+	case "replace":
+		changeType = "update"
+		err = s.server.keyValueService.Update(s.ctx, keyValue)
+	case "cas":
+		changeType = "update"
+		err = s.server.keyValueService.CompareAndSet(s.ctx, keyValue, int64(casUnique))
+	}
+
+	var result string
+	switch {
+	case err == nil:
+		result = "STORED"
+	case command == "add" && errors.Is(err, service.ErrKeyExists),
+		command == "replace" && errors.Is(err, service.ErrNotFound):
+		result = "NOT_STORED"
+	case command == "cas" && errors.Is(err, service.ErrVersionMismatch):
+		result = "EXISTS"
+	case command == "cas" && errors.Is(err, service.ErrNotFound):
+		result = "NOT_FOUND"
+	case errors.Is(err, service.ErrValidation):
+		// Such as empty values, which kvstored does not store.
+		s.reply("CLIENT_ERROR " + err.Error())
+		return
+	default:
+		s.serverError(err)
+		return
+	}
+
+	if err == nil {
+		if expired {
+			// A past exptime stores an item that is immediately invisible.
+			s.server.keyValueService.Delete(s.ctx, keyValue.AppID, keyValue.Key)
+			s.server.kafkaService.AsyncPublishKeyChange(s.ctx, "delete", keyValue.AppID, keyValue.Key, nil)
+			s.audit("delete", keyValue.Key, service.PreviousHash(previous), "")
+		} else {
+			s.server.kafkaService.AsyncPublishKeyChange(s.ctx, changeType, keyValue.AppID, keyValue.Key, &keyValue)
+			s.audit(changeType, keyValue.Key, service.PreviousHash(previous), service.HashValue(keyValue.Value))
+		}
+	}
+	if !noreply {
+		s.reply(result)
+	}
+}
+
+// delete implements delete <key> [noreply].
+func (s *session) delete(fields []string) {
+	if len(fields) < 2 || len(fields) > 3 {
+		s.reply("ERROR")
+		return
+	}
+	noreply := len(fields) == 3 && fields[2] == "noreply"
+	key := fields[1]
+	if !s.authorize(auth.ActionDelete, ratelimit.Write, key) {
+		return
+	}
+
+	current, err := s.server.keyValueService.Get(s.ctx, s.server.appID, key)
+	if errors.Is(err, service.ErrNotFound) {
+		if !noreply {
+			s.reply("NOT_FOUND")
+		}
+		return
+	} else if err != nil {
+		s.serverError(err)
+		return
+	}
+
+	if err := s.server.keyValueService.Delete(s.ctx, s.server.appID, key); err != nil {
+		s.serverError(err)
+		return
+	}
+	s.server.kafkaService.AsyncPublishKeyChange(s.ctx, "delete", s.server.appID, key, nil)
+	s.audit("delete", key, service.HashValue(current.Value), "")
+
+	if !noreply {
+		s.reply("DELETED")
+	}
+}
+
+// touch implements touch <key> <exptime> [noreply]. The value and its cas
+// unique are rewritten unchanged with the new TTL.
+func (s *session) touch(fields []string) {
+	if len(fields) < 3 || len(fields) > 4 {
+		s.reply("ERROR")
+		return
+	}
+	noreply := len(fields) == 4 && fields[3] == "noreply"
+	key := fields[1]
+	exptime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		s.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+	ttl, expired := ttlFromExptime(exptime)
+	action := auth.ActionWrite
+	if expired {
+		action = auth.ActionDelete
+	}
+	if !s.authorize(action, ratelimit.Write, key) {
+		return
+	}
+
+	result := "TOUCHED"
+	var hash string
+	for attempt := 0; ; attempt++ {
+		keyValue, err := s.server.keyValueService.Get(s.ctx, s.server.appID, key)
+		if err == nil {
+			hash = service.HashValue(keyValue.Value)
+		}
+		if err == nil {
+			if expired {
+				err = s.server.keyValueService.CompareAndDelete(s.ctx, s.server.appID, key, keyValue.Version)
+			} else {
+				keyValue.TTL = ttl
+				err = s.server.keyValueService.CompareAndSet(s.ctx, keyValue, keyValue.Version)
+			}
+		}
+
+		if errors.Is(err, service.ErrVersionMismatch) && attempt+1 < touchAttempts {
+			continue
+		}
+		if errors.Is(err, service.ErrNotFound) {
+			result = "NOT_FOUND"
+		} else if err != nil {
+			s.serverError(err)
+			return
+		}
+		break
+	}
+
+	if result == "TOUCHED" {
+		if expired {
+			s.server.kafkaService.AsyncPublishKeyChange(s.ctx, "delete", s.server.appID, key, nil)
+			s.audit("delete", key, hash, "")
+		} else {
+			s.audit("update", key, hash, hash)
+		}
+	}
+	if !noreply {
+		s.reply(result)
+	}
+}
+
+func (s *session) serverError(err error) {
+	s.reply("SERVER_ERROR " + err.Error())
+}
+
+// validKey applies memcached's key rules: at most 250 bytes without
+// whitespace or control characters.
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > 250 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// ttlFromExptime converts a memcached exptime into a TTL in seconds. expired
+// is true when the exptime lies in the past.
+func ttlFromExptime(exptime int64) (ttl int, expired bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return 0, true
+	case exptime <= relativeExptimeLimit:
+		return int(exptime), false
+	}
+
+	remaining := exptime - time.Now().Unix()
+	if remaining <= 0 {
+		return 0, true
+	}
+	return int(remaining), false
+}
+
+func encodeValue(flags uint32, data []byte) entity.KeyValue {
+	params := map[string]string{"flags": strconv.FormatUint(uint64(flags), 10)}
+	value := string(data)
+	if !utf8.Valid(data) {
+		params["encoding"] = "base64"
+		value = base64.StdEncoding.EncodeToString(data)
+	}
+
+	return entity.KeyValue{
+		Value:       value,
+		ContentType: mime.FormatMediaType(contentType, params),
+	}
+}
+
+// decodeValue returns the client flags and raw bytes of a value. Values
+// written through other frontends have no flags and are returned verbatim.
+func decodeValue(keyValue entity.KeyValue) (uint32, []byte, error) {
+	mediaType, params, err := mime.ParseMediaType(keyValue.ContentType)
+	if err != nil || mediaType != contentType {
+		return 0, []byte(keyValue.Value), nil
+	}
+
+	flags, _ := strconv.ParseUint(params["flags"], 10, 32)
+	if strings.EqualFold(params["encoding"], "base64") {
+		data, err := base64.StdEncoding.DecodeString(keyValue.Value)
+		if err != nil {
+			return 0, nil, fmt.Errorf("corrupt base64 value: %w", err)
+		}
+		return uint32(flags), data, nil
+	}
+	return uint32(flags), []byte(keyValue.Value), nil
+}
@@ -0,0 +1,180 @@
+// Package memcache serves the key-value store over the memcached text
+// protocol. Memcached has no notion of apps, so every connection of a Server
+// works in the single app it was created for. When authentication is enabled
+// a connection must first authenticate with memcached's ASCII authentication,
+// a set command whose data is "<username> <credential>"; the username is
+// ignored. Commands are authorized, rate limited and audited like their HTTP
+// counterparts.
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/ratelimit"
+	"github.com/keanutaufan/kvstored/api/realtime"
+	"github.com/keanutaufan/kvstored/api/service"
+)
+
+const (
+	// maxItemSize matches memcached's default item size limit.
+	maxItemSize = 1024 * 1024
+	// maxLineLength matches the longest command line memcached accepts.
+	maxLineLength = 2048
+)
+
+var errLineTooLong = errors.New("line too long")
+
+type Server struct {
+	keyValueService service.KeyValueService
+	kafkaService    *realtime.KafkaService
+	appID           string
+	authenticator   auth.Authenticator
+	limiter         *ratelimit.Limiter
+	auditService    service.AuditService
+
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	handlers sync.WaitGroup
+	mu       sync.Mutex
+}
+
+func NewServer(keyValueService service.KeyValueService, kafkaService *realtime.KafkaService, appID string, authenticator auth.Authenticator, limiter *ratelimit.Limiter, auditService service.AuditService) *Server {
+	return &Server{
+		keyValueService: keyValueService,
+		kafkaService:    kafkaService,
+		appID:           appID,
+		authenticator:   authenticator,
+		limiter:         limiter,
+		auditService:    auditService,
+		conns:           make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections on listener until Close is called.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return listener.Close()
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.handlers.Add(1)
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+// Close stops accepting connections, closes the open ones and waits for
+// their commands to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.handlers.Wait()
+	return err
+}
+
+func (s *Server) handle(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sess := &session{
+		server:     s,
+		ctx:        ctx,
+		reader:     bufio.NewReader(conn),
+		writer:     bufio.NewWriter(conn),
+		remoteAddr: conn.RemoteAddr().String(),
+	}
+	// Without authentication every connection is let in without a
+	// credential.
+	sess.principal, _ = s.authenticator.Authenticate(ctx, "")
+
+	defer func() {
+		defer s.handlers.Done()
+		cancel()
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	for {
+		line, err := readLine(sess.reader)
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				sess.reply("CLIENT_ERROR " + err.Error())
+				sess.writer.Flush()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Warn("Memcached connection failed", "remote_addr", conn.RemoteAddr().String(), "error", err)
+			}
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			sess.reply("ERROR")
+			continue
+		}
+
+		quit := sess.dispatch(fields)
+		if sess.reader.Buffered() == 0 || quit {
+			err = sess.writer.Flush()
+		}
+		if quit || err != nil {
+			return
+		}
+	}
+}
+
+// readLine reads a command line, failing with errLineTooLong rather than
+// buffering lines longer than maxLineLength.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLineLength {
+			return "", errLineTooLong
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			return string(line), nil
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Package metrics defines the Prometheus metrics the node exports at
+// /metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kvstored_http_requests_total",
+		Help: "HTTP requests by route and status.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kvstored_http_request_duration_seconds",
+		Help:    "HTTP request latency by route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	CassandraQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kvstored_cassandra_query_duration_seconds",
+		Help:    "Cassandra query latency by operation and table.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "table"})
+
+	CassandraQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kvstored_cassandra_query_errors_total",
+		Help: "Failed Cassandra queries by operation and table.",
+	}, []string{"operation", "table"})
+
+	KafkaPublishes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kvstored_kafka_publishes_total",
+		Help: "Key changes published to Kafka by result, ok or error.",
+	}, []string{"result"})
+
+	KafkaConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kvstored_kafka_consumer_lag",
+		Help: "Messages behind the end of each partition as of the last message consumed from it.",
+	}, []string{"partition"})
+
+	SocketClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kvstored_socket_clients",
+		Help: "Connected socket.io clients.",
+	})
+
+	SocketSubscriptions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kvstored_socket_subscriptions",
+		Help: "Socket.io subscriptions by app and kind, key or app.",
+	}, []string{"app_id", "kind"})
+)
+
+// Results of KafkaPublishes.
+const (
+	ResultOK    = "ok"
+	ResultError = "error"
+)
@@ -1,24 +1,28 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"strings"
 
 	"github.com/gocql/gocql"
-	"github.com/joho/godotenv"
-	"github.com/keanutaufan/kvstored/api/utils"
+	"github.com/keanutaufan/kvstored/api/config"
+	"github.com/keanutaufan/kvstored/api/db"
 )
 
 type CassandraMigration struct {
-	session *gocql.Session
+	session  *gocql.Session
+	keyspace string
 }
 
-func NewCassandraMigration(hosts []string) (*CassandraMigration, error) {
+func NewCassandraMigration(cfg config.Cassandra) (*CassandraMigration, error) {
 	// Create a cluster configuration
-	cluster := gocql.NewCluster(hosts...)
-	cluster.Consistency = gocql.Quorum
+	cluster, err := db.NewCluster(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a session to the default keyspace
 	session, err := cluster.CreateSession()
@@ -26,7 +30,7 @@ func NewCassandraMigration(hosts []string) (*CassandraMigration, error) {
 		return nil, fmt.Errorf("error creating Cassandra session: %v", err)
 	}
 
-	return &CassandraMigration{session: session}, nil
+	return &CassandraMigration{session: session, keyspace: cfg.Keyspace}, nil
 }
 
 func (m *CassandraMigration) RunMigrations() error {
@@ -42,35 +46,102 @@ func (m *CassandraMigration) RunMigrations() error {
 		return fmt.Errorf("error creating tables: %v", err)
 	}
 
+	// Add columns introduced after the tables were first created
+	err = m.addColumns()
+	if err != nil {
+		return fmt.Errorf("error adding columns: %v", err)
+	}
+
 	return nil
 }
 
 func (m *CassandraMigration) createKeyspace() error {
 	query := `
-		CREATE KEYSPACE IF NOT EXISTS kv_store_app 
+		CREATE KEYSPACE IF NOT EXISTS %[1]s 
 		WITH replication = {
 			'class': 'NetworkTopologyStrategy', 
 			'replication_factor': 3
 		}
 	`
-	return m.session.Query(query).Exec()
+	return m.session.Query(fmt.Sprintf(query, m.keyspace)).Exec()
 }
 
 func (m *CassandraMigration) createTables() error {
 	queries := []string{
 		`
-		CREATE TABLE IF NOT EXISTS kv_store_app.key_values (
+		CREATE TABLE IF NOT EXISTS %[1]s.key_values (
 			app_id text,
 			key text,
 			value text,
+			content_type text,
+			version bigint,
 			created_at timestamp,
 			PRIMARY KEY ((app_id), key)
 		)
 		`,
+		`
+		CREATE TABLE IF NOT EXISTS %[1]s.api_keys (
+			id text PRIMARY KEY,
+			name text,
+			key_hash text,
+			scopes map<text, text>,
+			roles list<text>,
+			created_at timestamp,
+			revoked_at timestamp
+		)
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS %[1]s.roles (
+			name text PRIMARY KEY,
+			policies text,
+			updated_at timestamp
+		)
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS %[1]s.rate_limits (
+			bucket text,
+			window_start bigint,
+			count counter,
+			PRIMARY KEY ((bucket, window_start))
+		)
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS %[1]s.audit_log (
+			app_id text,
+			day text,
+			id timeuuid,
+			key text,
+			action text,
+			actor text,
+			ip text,
+			user_agent text,
+			old_hash text,
+			new_hash text,
+			request_id text,
+			PRIMARY KEY ((app_id, day), id)
+		) WITH CLUSTERING ORDER BY (id DESC)
+		`,
+	}
+
+	for _, query := range queries {
+		if err := m.session.Query(fmt.Sprintf(query, m.keyspace)).Exec(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *CassandraMigration) addColumns() error {
+	queries := []string{
+		`ALTER TABLE %[1]s.key_values ADD content_type text`,
+		`ALTER TABLE %[1]s.key_values ADD version bigint`,
+		`ALTER TABLE %[1]s.api_keys ADD roles list<text>`,
 	}
 
 	for _, query := range queries {
-		if err := m.session.Query(query).Exec(); err != nil {
+		err := m.session.Query(fmt.Sprintf(query, m.keyspace)).Exec()
+		if err != nil && !strings.Contains(err.Error(), "conflicts with an existing column") {
 			return err
 		}
 	}
@@ -85,15 +156,12 @@ func (m *CassandraMigration) Close() {
 }
 
 func main() {
-	if os.Getenv("APP_ENV") != "production" {
-		err := godotenv.Load(".env")
-		if err != nil {
-			panic(err)
-		}
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
 
-	cqlHosts := utils.LoadEnv("CASSANDRA_HOSTS", "localhost")
-	migration, err := NewCassandraMigration(strings.Split(cqlHosts, ","))
+	migration, err := NewCassandraMigration(cfg.Cassandra)
 	if err != nil {
 		log.Fatalf("Failed to create migration: %v", err)
 	}
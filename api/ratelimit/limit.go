@@ -0,0 +1,135 @@
+// Package ratelimit limits how often each client and each app may read,
+// write and subscribe.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keanutaufan/kvstored/api/repository"
+)
+
+// Class is a kind of request with its own limits.
+type Class string
+
+const (
+	Read      Class = "read"
+	Write     Class = "write"
+	Subscribe Class = "subscribe"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second and holding at
+// most Burst tokens. The zero Limit is unlimited.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%g/s:%d", l.Rate, l.Burst)
+}
+
+// window returns the fixed window that approximates the bucket when it is
+// shared across nodes: the time a drained bucket takes to refill, and how
+// many requests it allows in that time.
+func (l Limit) window() (time.Duration, int64) {
+	size := max(time.Second, time.Duration(float64(l.Burst)/l.Rate*float64(time.Second)))
+	return size, max(int64(l.Burst), int64(math.Ceil(l.Rate*size.Seconds())))
+}
+
+var periods = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// ParseLimit parses "unlimited" or "<count>/<s|m|h>[:<burst>]", such as
+// "50/s:100" or "600/m". The burst defaults to one second's worth of
+// requests, and at least one.
+func ParseLimit(s string) (Limit, error) {
+	if s == "unlimited" {
+		return Limit{}, nil
+	}
+
+	rate, burst, hasBurst := strings.Cut(s, ":")
+	count, period, ok := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(count, 64)
+	if !ok || err != nil || n <= 0 || periods[period] == 0 {
+		return Limit{}, repository.NewError(repository.ErrValidation, fmt.Sprintf("invalid rate limit %q, must be unlimited or like 50/s:100", s))
+	}
+
+	limit := Limit{Rate: n / periods[period].Seconds()}
+	limit.Burst = max(1, int(math.Ceil(limit.Rate)))
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 1 {
+			return Limit{}, repository.NewError(repository.ErrValidation, fmt.Sprintf("invalid burst in rate limit %q", s))
+		}
+	}
+	return limit, nil
+}
+
+// Rules maps "client:<subject>:<class>" and "app:<app ID>:<class>" to
+// limits. A "*" subject or app ID sets the default for its class.
+type Rules map[string]Limit
+
+// DefaultRules returns limits generous enough for well-behaved clients.
+func DefaultRules() Rules {
+	return Rules{
+		"client:*:read":      {Rate: 200, Burst: 400},
+		"client:*:write":     {Rate: 50, Burst: 100},
+		"client:*:subscribe": {Rate: 5, Burst: 20},
+		"app:*:read":         {Rate: 1000, Burst: 2000},
+		"app:*:write":        {Rate: 200, Burst: 400},
+		"app:*:subscribe":    {Rate: 50, Burst: 100},
+	}
+}
+
+// Parse adds comma separated "<rule>=<limit>" entries to r, replacing any
+// existing limits for the same rules, for example
+// "client:*:write=20/s:40,app:billing:read=unlimited".
+func (r Rules) Parse(s string) error {
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		rule, value, ok := strings.Cut(entry, "=")
+		scope, rest, _ := strings.Cut(rule, ":")
+		i := strings.LastIndexByte(rest, ':')
+		if !ok || (scope != "client" && scope != "app") || i <= 0 {
+			return repository.NewError(repository.ErrValidation, fmt.Sprintf("invalid rate limit rule %q, must be like client:*:write=50/s", entry))
+		}
+		switch Class(rest[i+1:]) {
+		case Read, Write, Subscribe:
+		default:
+			return repository.NewError(repository.ErrValidation, fmt.Sprintf("unknown class in rate limit rule %q, must be read, write or subscribe", entry))
+		}
+
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return err
+		}
+		r[rule] = limit
+	}
+	return nil
+}
+
+// lookup returns the limit for id in scope, falling back to the scope's
+// default. Without either the class is unlimited.
+func (r Rules) lookup(scope, id string, class Class) Limit {
+	if limit, ok := r[scope+":"+id+":"+string(class)]; ok {
+		return limit
+	}
+	return r[scope+":*:"+string(class)]
+}
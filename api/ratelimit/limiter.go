@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/keanutaufan/kvstored/api/auth"
+)
+
+// ErrRateLimited is the kind of every *Error, matched with errors.Is.
+var ErrRateLimited = errors.New("rate limited")
+
+// Error reports an exhausted bucket.
+type Error struct {
+	Bucket     string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rate limit of %s exceeded, retry in %s", e.Bucket, e.RetryAfter.Round(time.Millisecond))
+}
+
+func (e *Error) Unwrap() error {
+	return ErrRateLimited
+}
+
+// Store holds token buckets.
+type Store interface {
+	// Take takes a token from bucket. It returns zero when a token was
+	// available, and otherwise how long until one will be.
+	Take(bucket string, limit Limit) time.Duration
+}
+
+type Limiter struct {
+	rules Rules
+	store Store
+}
+
+// New returns a Limiter enforcing rules with buckets kept in store. A nil
+// *Limiter allows everything.
+func New(rules Rules, store Store) *Limiter {
+	return &Limiter{rules: rules, store: store}
+}
+
+// Client returns the client bucket a request of principal from addr is
+// charged to: the principal's subject, or the host of addr for anonymous
+// callers, who would otherwise share one bucket.
+func Client(principal *auth.Principal, addr string) string {
+	if principal != nil && principal.Subject != auth.Anonymous {
+		return principal.Subject
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// Allow charges a request of class to the buckets of client, as returned by
+// Client, and of appID. It fails with an *Error when either
+// is exhausted.
+func (l *Limiter) Allow(client, appID string, class Class) error {
+	if l == nil {
+		return nil
+	}
+
+	for _, bucket := range []struct{ scope, id string }{{"client", client}, {"app", appID}} {
+		limit := l.rules.lookup(bucket.scope, bucket.id, class)
+		if limit.Unlimited() {
+			continue
+		}
+
+		name := bucket.scope + ":" + bucket.id + ":" + string(class)
+		if retryAfter := l.store.Take(name, limit); retryAfter > 0 {
+			return &Error{Bucket: name, RetryAfter: retryAfter}
+		}
+	}
+	return nil
+}
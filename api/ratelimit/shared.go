@@ -0,0 +1,181 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/keanutaufan/kvstored/api/repository"
+)
+
+const (
+	// syncInterval is how often local counts are added to the shared
+	// counters and the other nodes' counts read back.
+	syncInterval = 200 * time.Millisecond
+	// syncQueryTimeout bounds each repository query of a sync.
+	syncQueryTimeout = time.Second
+	// expiredWindowGrace delays deleting the counter of a finished window,
+	// so nodes with a slightly slow clock do not increment a deleted
+	// counter.
+	expiredWindowGrace = time.Minute
+)
+
+type window struct {
+	index   int64 // start time in units of size since the epoch
+	size    time.Duration
+	allowed int64
+	counted int64 // cluster-wide count at the last sync
+	pending int64 // requests allowed here since the last sync
+}
+
+type expiredWindow struct {
+	bucket   string
+	index    int64
+	deleteAt time.Time
+}
+
+type sharedStore struct {
+	repository repository.RateLimitRepository
+
+	windows map[string]*window
+	expired []expiredWindow // in no particular order, as windows differ per limit
+	mu      sync.Mutex
+
+	done chan struct{}
+}
+
+// NewSharedStore returns a Store whose limits hold across all nodes. Each
+// bucket is approximated by a fixed window counted in the repository: nodes
+// count requests locally and sync every syncInterval, so a burst may
+// briefly overshoot by what other nodes allowed since their last sync. When
+// the repository is unavailable each node keeps counting on its own.
+func NewSharedStore(rateLimitRepository repository.RateLimitRepository) *sharedStore {
+	s := &sharedStore{
+		repository: rateLimitRepository,
+		windows:    make(map[string]*window),
+		done:       make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *sharedStore) Take(name string, limit Limit) time.Duration {
+	now := time.Now()
+	size, allowed := limit.window()
+	index := now.UnixNano() / int64(size)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[name]
+	if !ok || w.index != index || w.size != size {
+		if ok {
+			s.expire(name, w)
+		}
+		w = &window{index: index, size: size, allowed: allowed}
+		s.windows[name] = w
+	}
+
+	if w.counted+w.pending >= w.allowed {
+		return time.Duration((index+1)*int64(size) - now.UnixNano())
+	}
+	w.pending++
+	return 0
+}
+
+func (s *sharedStore) Close() {
+	close(s.done)
+}
+
+func (s *sharedStore) run() {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.sync()
+		}
+	}
+}
+
+func (s *sharedStore) expire(name string, w *window) {
+	end := time.Unix(0, (w.index+1)*int64(w.size))
+	s.expired = append(s.expired, expiredWindow{bucket: name, index: w.index, deleteAt: end.Add(expiredWindowGrace)})
+}
+
+func (s *sharedStore) sync() {
+	type update struct {
+		bucket string
+		window *window
+		n      int64
+	}
+
+	now := time.Now()
+	var updates []update
+	var deletes []expiredWindow
+
+	s.mu.Lock()
+	for name, w := range s.windows {
+		if now.UnixNano()/int64(w.size) != w.index {
+			s.expire(name, w)
+			delete(s.windows, name)
+			continue
+		}
+		updates = append(updates, update{bucket: name, window: w, n: w.pending})
+	}
+	waiting := s.expired[:0]
+	for _, e := range s.expired {
+		if now.After(e.deleteAt) {
+			deletes = append(deletes, e)
+		} else {
+			waiting = append(waiting, e)
+		}
+	}
+	s.expired = waiting
+	s.mu.Unlock()
+
+	for _, u := range updates {
+		var count int64
+		var err error
+		ctx, cancel := context.WithTimeout(context.Background(), syncQueryTimeout)
+		if u.n > 0 {
+			count, err = s.repository.Add(ctx, u.bucket, u.window.index, u.n)
+		} else {
+			count, err = s.repository.Get(ctx, u.bucket, u.window.index)
+		}
+		cancel()
+		if err != nil {
+			// The remaining counts are synced on the next tick.
+			slog.Error("Failed to sync rate limit", "bucket", u.bucket, "error", err)
+			s.requeue(deletes)
+			return
+		}
+
+		s.mu.Lock()
+		u.window.pending -= u.n
+		u.window.counted = count
+		s.mu.Unlock()
+	}
+
+	for i, d := range deletes {
+		ctx, cancel := context.WithTimeout(context.Background(), syncQueryTimeout)
+		err := s.repository.Delete(ctx, d.bucket, d.index)
+		cancel()
+		if err != nil {
+			slog.Error("Failed to delete rate limit window", "bucket", d.bucket, "error", err)
+			s.requeue(deletes[i:])
+			return
+		}
+	}
+}
+
+// requeue puts back windows whose counters a sync did not get to delete.
+func (s *sharedStore) requeue(deletes []expiredWindow) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expired = append(s.expired, deletes...)
+}
@@ -0,0 +1,114 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+// subscriptionBuffer is how many changes a subscriber may fall behind before
+// its subscription is ended.
+const subscriptionBuffer = 64
+
+// ErrSlowSubscriber ends subscriptions whose subscriber fell too far behind
+// to be sent a change.
+var ErrSlowSubscriber = errors.New("subscriber fell too far behind and missed changes")
+
+// Broker fans key changes out to in-process subscribers, such as gRPC Watch
+// streams, that are not socket.io clients.
+type Broker struct {
+	subs map[string]map[*Subscription]struct{} // appID -> subscriptions
+	mu   sync.Mutex
+}
+
+// Subscription receives the changes of one key, or of every key of an app
+// when Key is empty.
+type Subscription struct {
+	AppID string
+	Key   string
+	C     <-chan KeyChangeMessage
+
+	c   chan KeyChangeMessage
+	err error // set before c is closed
+}
+
+// Err returns ErrSlowSubscriber once C is closed because the subscriber fell
+// behind, and nil otherwise.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subs: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+func (b *Broker) Subscribe(appID, key string) *Subscription {
+	c := make(chan KeyChangeMessage, subscriptionBuffer)
+	sub := &Subscription{AppID: appID, Key: key, C: c, c: c}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[appID] == nil {
+		b.subs[appID] = make(map[*Subscription]struct{})
+	}
+	b.subs[appID][sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe stops delivery to sub and closes its channel.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(sub)
+}
+
+// remove closes sub if it is still subscribed. b.mu must be held.
+func (b *Broker) remove(sub *Subscription) {
+	subs, ok := b.subs[sub.AppID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.subs, sub.AppID)
+	}
+	close(sub.c)
+}
+
+func (b *Broker) HandleKeyChange(ctx context.Context, keyChange KeyChangeMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs[keyChange.AppID] {
+		if sub.Key != "" && sub.Key != keyChange.Key {
+			continue
+		}
+		select {
+		case sub.c <- keyChange:
+		default:
+			// The subscriber would miss the change, so its subscription is
+			// ended for it to resubscribe and read the keys again.
+			slog.WarnContext(ctx, "Ending the subscription of a slow subscriber", "type", keyChange.Type, "app_id", keyChange.AppID, "key", keyChange.Key)
+			sub.err = ErrSlowSubscriber
+			b.remove(sub)
+		}
+	}
+}
+
+// Close ends every subscription by closing its channel, so watchers such as
+// gRPC Watch streams return and their clients reconnect to another node.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for appID, subs := range b.subs {
+		for sub := range subs {
+			close(sub.c)
+		}
+		delete(b.subs, appID)
+	}
+}
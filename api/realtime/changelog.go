@@ -0,0 +1,185 @@
+package realtime
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Cursor is a position in the key change topic: the offset of the last
+// change seen in each partition.
+type Cursor map[int]int64
+
+// ParseCursor parses a cursor formatted by String, such as "0:15,1:22".
+func ParseCursor(s string) (Cursor, error) {
+	cursor := make(Cursor)
+	if s == "" {
+		return cursor, nil
+	}
+	for _, position := range strings.Split(s, ",") {
+		partition, offset, ok := strings.Cut(position, ":")
+		p, err := strconv.Atoi(partition)
+		if !ok || err != nil || p < 0 {
+			return nil, fmt.Errorf("invalid cursor %q", s)
+		}
+		o, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil || o < 0 {
+			return nil, fmt.Errorf("invalid cursor %q", s)
+		}
+		cursor[p] = o
+	}
+	return cursor, nil
+}
+
+func (c Cursor) String() string {
+	partitions := make([]int, 0, len(c))
+	for partition := range c {
+		partitions = append(partitions, partition)
+	}
+	sort.Ints(partitions)
+
+	positions := make([]string, 0, len(partitions))
+	for _, partition := range partitions {
+		positions = append(positions, fmt.Sprintf("%d:%d", partition, c[partition]))
+	}
+	return strings.Join(positions, ",")
+}
+
+func (c Cursor) clone() Cursor {
+	clone := make(Cursor, len(c))
+	for partition, offset := range c {
+		clone[partition] = offset
+	}
+	return clone
+}
+
+// seen reports whether the change at partition and offset is at or before
+// the cursor.
+func (c Cursor) seen(partition int, offset int64) bool {
+	last, ok := c[partition]
+	return ok && offset <= last
+}
+
+// ChangeLog keeps the most recent key changes consumed, so watchers can
+// follow them and watchers that reconnect, to this node or another one, can
+// be sent the changes they missed.
+type ChangeLog struct {
+	changes []KeyChangeMessage // ring buffer of the last len(changes) changes
+	next    int                // index the next change is written to
+	full    bool
+	head    Cursor        // last change consumed in each partition
+	first   Cursor        // first change consumed in each partition
+	evicted Cursor        // last change evicted from the buffer in each partition
+	changed chan struct{} // closed and replaced on every change
+	closed  bool
+	mu      sync.Mutex
+}
+
+// NewChangeLog returns a log keeping the last size changes. Watchers are sent
+// changes from the log, so size must be positive.
+func NewChangeLog(size int) *ChangeLog {
+	return &ChangeLog{
+		changes: make([]KeyChangeMessage, size),
+		head:    make(Cursor),
+		first:   make(Cursor),
+		evicted: make(Cursor),
+		changed: make(chan struct{}),
+	}
+}
+
+func (l *ChangeLog) HandleKeyChange(ctx context.Context, keyChange KeyChangeMessage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+
+	if l.full {
+		evicted := l.changes[l.next]
+		l.evicted[evicted.Partition] = evicted.Offset
+	}
+	if _, ok := l.first[keyChange.Partition]; !ok {
+		l.first[keyChange.Partition] = keyChange.Offset
+	}
+	l.changes[l.next] = keyChange
+	l.next = (l.next + 1) % len(l.changes)
+	if l.next == 0 {
+		l.full = true
+	}
+	l.head[keyChange.Partition] = keyChange.Offset
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Head returns the position of the last change consumed.
+func (l *ChangeLog) Head() Cursor {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.head.clone()
+}
+
+// Since returns the changes after cursor to the keys of appID, or only to
+// key if it is not empty, and the position of the last change consumed.
+// complete is false when the log no longer holds every change after
+// cursor. changed is closed on the next change, or when the log is closed.
+func (l *ChangeLog) Since(cursor Cursor, appID, key string) (changes []KeyChangeMessage, head Cursor, complete bool, changed <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ordered := l.changes[:l.next]
+	if l.full {
+		ordered = append(l.changes[l.next:len(l.changes):len(l.changes)], l.changes[:l.next]...)
+	}
+
+	complete = true
+	for partition, offset := range l.evicted {
+		if !cursor.seen(partition, offset) {
+			complete = false
+		}
+	}
+	for partition, offset := range l.first {
+		if last, ok := cursor[partition]; ok && last < offset-1 {
+			complete = false
+		}
+	}
+
+	for _, change := range ordered {
+		if cursor.seen(change.Partition, change.Offset) {
+			continue
+		}
+		if change.AppID != appID || (key != "" && change.Key != key) {
+			continue
+		}
+		changes = append(changes, change)
+	}
+
+	head = cursor.clone()
+	for partition, offset := range l.head {
+		if !head.seen(partition, offset) {
+			head[partition] = offset
+		}
+	}
+	return changes, head, complete, l.changed
+}
+
+// Close wakes every watcher for good, so their streams end and their
+// clients reconnect to another node.
+func (l *ChangeLog) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.changed)
+	}
+}
+
+// Closed reports whether Close was called.
+func (l *ChangeLog) Closed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
@@ -3,11 +3,27 @@ package realtime
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/keanutaufan/kvstored/api/certs"
+	"github.com/keanutaufan/kvstored/api/config"
 	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/logging"
+	"github.com/keanutaufan/kvstored/api/metrics"
+	"github.com/keanutaufan/kvstored/api/tracing"
 	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type KeyChangeMessage struct {
@@ -15,32 +31,144 @@ type KeyChangeMessage struct {
 	AppID string           `json:"app_id"`
 	Key   string           `json:"key"`
 	Value *entity.KeyValue `json:"value,omitempty"`
+
+	// Position of the message in the topic, set by the consumer. Every node
+	// consumes the whole topic, so it identifies the change on any node.
+	Partition int   `json:"-"`
+	Offset    int64 `json:"-"`
 }
 
+// requestIDHeader carries the ID of the request that made a change, so the
+// nodes delivering it log under the same ID.
+const requestIDHeader = "kvstored-request-id"
+
+// consumerFailureGrace is how long the consumer may fail to read before it
+// is reported unhealthy, so a broker restart does not take nodes out of
+// rotation.
+const consumerFailureGrace = 30 * time.Second
+
+// publishFailureWindow is how long a failed publish keeps the writer
+// reported unhealthy. Publishes only happen on writes, so without it a node
+// that saw no writes since a broker outage would never become ready again.
+const publishFailureWindow = 30 * time.Second
+
 type KafkaService struct {
-	writer *kafka.Writer
-	reader *kafka.Reader
+	brokers []string
+	topic   string
+	dialer  *kafka.Dialer
+	writer  *kafka.Writer
+	reader  *kafka.Reader
+
+	publishes    sync.WaitGroup // AsyncPublishKeyChange calls in flight
+	consumerCtx  context.Context
+	stopConsumer context.CancelFunc
+	consumerDone chan struct{}
+
+	consuming     bool
+	publishErr    error
+	publishedAt   time.Time // time of the last publish
+	readErr       error
+	readFailingAt time.Time // start of the current run of read errors
+	mu            sync.Mutex
 }
 
-func NewKafkaService(brokers []string, groupID string) *KafkaService {
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: brokers,
-		Topic:   "kvstore",
-	})
+func NewKafkaService(cfg config.Kafka) (*KafkaService, error) {
+	dialer, err := newDialer(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// The writer is given a transport of its own, as writers created from a
+	// WriterConfig ignore the dialer's DialFunc.
+	writer := &kafka.Writer{
+		Addr:  kafka.TCP(cfg.Brokers...),
+		Topic: cfg.Topic,
+		Transport: &kafka.Transport{
+			Dial:        dialer.DialFunc,
+			DialTimeout: dialer.Timeout,
+			SASL:        dialer.SASLMechanism,
+		},
+	}
 
 	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: brokers,
-		Topic:   "kvstore",
-		GroupID: groupID,
+		Brokers: cfg.Brokers,
+		Topic:   cfg.Topic,
+		GroupID: cfg.GroupID,
+		Dialer:  dialer,
 	})
 
+	consumerCtx, stopConsumer := context.WithCancel(context.Background())
 	return &KafkaService{
-		writer: writer,
-		reader: reader,
+		brokers:      cfg.Brokers,
+		topic:        cfg.Topic,
+		dialer:       dialer,
+		writer:       writer,
+		reader:       reader,
+		consumerCtx:  consumerCtx,
+		stopConsumer: stopConsumer,
+		consumerDone: make(chan struct{}),
+	}, nil
+}
+
+// newDialer returns a dialer connecting to brokers with the TLS and SASL
+// settings of cfg.
+func newDialer(cfg config.Kafka) (*kafka.Dialer, error) {
+	dialer := &kafka.Dialer{
+		Timeout:   10 * time.Second,
+		DualStack: true,
 	}
+
+	if cfg.TLS.Enabled {
+		store, err := certs.New(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.CAFile, cfg.TLS.ReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		// The handshake is run here rather than through dialer.TLS, so
+		// brokers are verified against the host dialed even when it is an
+		// IP address.
+		tcp := &net.Dialer{DualStack: true}
+		dialer.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := tcp.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				host = address
+			}
+			return store.Client(ctx, conn, host, cfg.TLS.ServerName, !cfg.TLS.InsecureSkipVerify)
+		}
+	}
+
+	var err error
+	switch cfg.SASL.Mechanism {
+	case "plain":
+		dialer.SASLMechanism = plain.Mechanism{Username: cfg.SASL.Username, Password: cfg.SASL.Password}
+	case "scram-sha-256":
+		dialer.SASLMechanism, err = scram.Mechanism(scram.SHA256, cfg.SASL.Username, cfg.SASL.Password)
+	case "scram-sha-512":
+		dialer.SASLMechanism, err = scram.Mechanism(scram.SHA512, cfg.SASL.Username, cfg.SASL.Password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dialer, nil
 }
 
-func (k *KafkaService) PublishKeyChange(msgType string, appID, key string, value *entity.KeyValue) error {
+// PublishKeyChange publishes a key change, with the trace context of ctx in
+// the message headers so consumers on other nodes continue the trace.
+func (k *KafkaService) PublishKeyChange(ctx context.Context, msgType string, appID, key string, value *entity.KeyValue) (err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "kafka publish "+k.topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(k.topic),
+			attribute.String("kvstored.app_id", appID),
+			attribute.String("kvstored.key", key),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
 	msg := KeyChangeMessage{
 		Type:  msgType,
 		AppID: appID,
@@ -53,55 +181,246 @@ func (k *KafkaService) PublishKeyChange(msgType string, appID, key string, value
 		return err
 	}
 
-	return k.writer.WriteMessages(context.Background(),
-		kafka.Message{
-			Value: payload,
-			Time:  time.Now(),
-		},
-	)
+	message := kafka.Message{
+		Value: payload,
+		Time:  time.Now(),
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{&message.Headers})
+	if id := logging.RequestID(ctx); id != "" {
+		headerCarrier{&message.Headers}.Set(requestIDHeader, id)
+	}
+	err = k.writer.WriteMessages(ctx, message)
+
+	k.mu.Lock()
+	k.publishErr, k.publishedAt = err, time.Now()
+	k.mu.Unlock()
+
+	if err != nil {
+		metrics.KafkaPublishes.WithLabelValues(metrics.ResultError).Inc()
+	} else {
+		metrics.KafkaPublishes.WithLabelValues(metrics.ResultOK).Inc()
+	}
+	return err
+}
+
+// KeyChangeHandler receives every key change read from Kafka.
+type KeyChangeHandler interface {
+	// HandleKeyChange is called with a context carrying the span of the
+	// consumed message.
+	HandleKeyChange(ctx context.Context, keyChange KeyChangeMessage)
 }
 
-func (k *KafkaService) StartConsumer(socketServer *SocketServer) {
+// StartConsumer passes every key change to handlers until Shutdown. A
+// message's offset is committed once handlers have received it, so a node
+// stopped while delivering a change delivers it again when it restarts.
+func (k *KafkaService) StartConsumer(handlers ...KeyChangeHandler) {
+	k.mu.Lock()
+	k.consuming = true
+	k.mu.Unlock()
+	defer func() {
+		k.mu.Lock()
+		k.consuming = false
+		k.mu.Unlock()
+		close(k.consumerDone)
+	}()
+
 	for {
-		msg, err := k.reader.ReadMessage(context.Background())
+		msg, err := k.reader.FetchMessage(k.consumerCtx)
+		if k.consumerCtx.Err() != nil {
+			return
+		}
+		k.recordRead(err)
 		if err != nil {
-			log.Printf("Error reading Kafka message: %v", err)
+			slog.Error("Failed to read Kafka message", "error", err)
 			time.Sleep(time.Second)
 			continue
 		}
+		metrics.KafkaConsumerLag.WithLabelValues(strconv.Itoa(msg.Partition)).Set(float64(msg.HighWaterMark - msg.Offset - 1))
+		k.handle(msg, handlers)
 
-		var keyChange KeyChangeMessage
-		if err := json.Unmarshal(msg.Value, &keyChange); err != nil {
-			log.Printf("Error unmarshaling message: %v", err)
-			continue
+		if err := k.reader.CommitMessages(context.Background(), msg); err != nil {
+			slog.Error("Failed to commit Kafka offset", "partition", msg.Partition, "offset", msg.Offset, "error", err)
 		}
+	}
+}
 
-		switch keyChange.Type {
-		case "set":
-			if keyChange.Value != nil {
-				socketServer.NotifyKeySet(*keyChange.Value)
-			}
-		case "update":
-			if keyChange.Value != nil {
-				socketServer.NotifyKeyUpdated(*keyChange.Value)
-			}
-		case "delete":
-			socketServer.NotifyKeyDeleted(keyChange.AppID, keyChange.Key)
-		}
+// handle passes a consumed message to handlers under a span continuing the
+// trace of its publisher.
+func (k *KafkaService) handle(msg kafka.Message, handlers []KeyChangeHandler) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier{&msg.Headers})
+	if id := (headerCarrier{&msg.Headers}).Get(requestIDHeader); id != "" {
+		ctx = logging.WithRequestID(ctx, id)
+	}
+	ctx, span := tracing.Tracer.Start(ctx, "kafka consume "+k.topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(k.topic),
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(msg.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+			attribute.Int64("kvstored.delivery_delay_ms", time.Since(msg.Time).Milliseconds()),
+		),
+	)
+
+	var keyChange KeyChangeMessage
+	if err := json.Unmarshal(msg.Value, &keyChange); err != nil {
+		slog.ErrorContext(ctx, "Failed to unmarshal Kafka message", "partition", msg.Partition, "offset", msg.Offset, "error", err)
+		tracing.End(span, err)
+		return
+	}
+	keyChange.Partition, keyChange.Offset = msg.Partition, msg.Offset
+
+	for _, handler := range handlers {
+		handler.HandleKeyChange(ctx, keyChange)
 	}
+	span.End()
 }
 
-func (k *KafkaService) AsyncPublishKeyChange(msgType string, appID, key string, value *entity.KeyValue) {
+// AsyncPublishKeyChange publishes a key change in the background. The
+// publish outlives ctx, but continues its trace.
+func (k *KafkaService) AsyncPublishKeyChange(ctx context.Context, msgType string, appID, key string, value *entity.KeyValue) {
+	ctx = context.WithoutCancel(ctx)
+	k.publishes.Add(1)
 	go func() {
-		if err := k.PublishKeyChange(msgType, appID, key, value); err != nil {
-			log.Printf("Error publishing Kafka message: %v", err)
+		defer k.publishes.Done()
+		if err := k.PublishKeyChange(ctx, msgType, appID, key, value); err != nil {
+			slog.ErrorContext(ctx, "Failed to publish key change", "type", msgType, "app_id", appID, "key", key, "error", err)
 		}
 	}()
 }
 
+// Shutdown waits for pending asynchronous publishes, stops the consumer once
+// the change it is delivering has been committed, and closes the writer and
+// reader. It stops waiting when ctx is done.
+func (k *KafkaService) Shutdown(ctx context.Context) error {
+	published := make(chan struct{})
+	go func() {
+		k.publishes.Wait()
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-ctx.Done():
+		slog.Warn("Gave up waiting for pending Kafka publishes", "error", ctx.Err())
+	}
+
+	k.stopConsumer()
+	k.mu.Lock()
+	consuming := k.consuming
+	k.mu.Unlock()
+	if consuming {
+		select {
+		case <-k.consumerDone:
+		case <-ctx.Done():
+			slog.Warn("Gave up waiting for the Kafka consumer to stop", "error", ctx.Err())
+		}
+	}
+
+	return k.Close()
+}
+
 func (k *KafkaService) Close() error {
 	if err := k.writer.Close(); err != nil {
 		return err
 	}
 	return k.reader.Close()
 }
+
+// headerCarrier lets propagators read and write trace context in the
+// headers of a Kafka message.
+type headerCarrier struct {
+	headers *[]kafka.Header
+}
+
+func (c headerCarrier) Get(key string) string {
+	for _, header := range *c.headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) {
+	for i, header := range *c.headers {
+		if header.Key == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, len(*c.headers))
+	for i, header := range *c.headers {
+		keys[i] = header.Key
+	}
+	return keys
+}
+
+func (k *KafkaService) recordRead(err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err != nil && k.readErr == nil {
+		k.readFailingAt = time.Now()
+	}
+	k.readErr = err
+}
+
+// CheckWriter checks that a broker is reachable and knows the topic, and
+// that the last publish did not fail within publishFailureWindow.
+func (k *KafkaService) CheckWriter(ctx context.Context) error {
+	var err error
+	for _, broker := range k.brokers {
+		if err = k.checkBroker(ctx, broker); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.publishErr != nil && time.Since(k.publishedAt) < publishFailureWindow {
+		return fmt.Errorf("last publish failed at %s: %w", k.publishedAt.Format(time.RFC3339), k.publishErr)
+	}
+	return nil
+}
+
+func (k *KafkaService) checkBroker(ctx context.Context, broker string) error {
+	conn, err := k.dialer.DialContext(ctx, "tcp", broker)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	partitions, err := conn.ReadPartitions(k.topic)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("topic %s has no partitions", k.topic)
+	}
+	return nil
+}
+
+// CheckReader checks that the consumer is running and has not been failing
+// to read for longer than consumerFailureGrace.
+func (k *KafkaService) CheckReader(ctx context.Context) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if !k.consuming {
+		return errors.New("consumer is not running")
+	}
+	if k.readErr != nil && time.Since(k.readFailingAt) > consumerFailureGrace {
+		return fmt.Errorf("consumer failing since %s: %w", k.readFailingAt.Format(time.RFC3339), k.readErr)
+	}
+	return nil
+}
@@ -1,113 +1,475 @@
 package realtime
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"net/url"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	socketio "github.com/googollee/go-socket.io"
+	"github.com/googollee/go-socket.io/engineio"
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/config"
 	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/logging"
+	"github.com/keanutaufan/kvstored/api/metrics"
+	"github.com/keanutaufan/kvstored/api/ratelimit"
+	"github.com/keanutaufan/kvstored/api/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Kinds of subscription counted by metrics.SocketSubscriptions.
+const (
+	subscriptionKey = "key"
+	subscriptionApp = "app"
 )
 
 type SocketServer struct {
-	Server  *socketio.Server
-	keySubs map[string]map[string]map[string]socketio.Conn // appID -> key -> clientID -> connection
-	appSubs map[string]map[string]socketio.Conn            // appID -> clientID -> connection
-	mu      sync.Mutex
+	Server        *socketio.Server
+	authenticator auth.Authenticator
+	limiter       *ratelimit.Limiter
+	cfg           config.Socket
+	serving       atomic.Bool
+	conns         map[string]conn                       // clientID -> connection
+	keySubs       map[string]map[string]map[string]conn // appID -> key -> clientID -> connection
+	appSubs       map[string]map[string]conn            // appID -> clientID -> connection
+	mu            sync.Mutex
 }
 
-func NewSocketServer() *SocketServer {
+// conn is a connected client: a socket.io connection, or a connection
+// speaking the plain WebSocket protocol of ServeWebSocket. Its context is
+// the *auth.Principal it authenticated as.
+type conn interface {
+	ID() string
+	Context() interface{}
+	Emit(event string, v ...interface{})
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+// NewSocketServer returns a socket.io server that authenticates connections
+// with authenticator and only lets clients subscribe to keys they may
+// subscribe to. App subscribers only receive changes to those keys.
+// Subscriptions are charged to the subscribe rate limits of limiter.
+// Browsers, which cannot set headers on WebSocket handshakes, may pass the
+// credential as the token or api_key query parameter instead. Clients are
+// pinged as set by cfg, or with engine.io's defaults where it is zero.
+func NewSocketServer(authenticator auth.Authenticator, limiter *ratelimit.Limiter, cfg config.Socket) *SocketServer {
 	s := &SocketServer{
-		Server:  socketio.NewServer(nil),
-		keySubs: make(map[string]map[string]map[string]socketio.Conn),
-		appSubs: make(map[string]map[string]socketio.Conn),
+		Server: socketio.NewServer(&engineio.Options{
+			PingInterval: cfg.PingInterval,
+			PingTimeout:  cfg.PingTimeout,
+		}),
+		authenticator: authenticator,
+		limiter:       limiter,
+		cfg:           cfg,
+		conns:         make(map[string]conn),
+		keySubs:       make(map[string]map[string]map[string]conn),
+		appSubs:       make(map[string]map[string]conn),
 	}
 
 	s.Server.OnConnect("/", func(so socketio.Conn) error {
-		log.Println("Connected:", so.ID())
+		credential := auth.Credential(so.RemoteHeader())
+		if credential == "" {
+			requestURL := so.URL()
+			credential = queryCredential(requestURL.Query())
+		}
+
+		ctx := eventContext()
+		principal, err := authenticator.Authenticate(ctx, credential)
+		if err != nil {
+			slog.InfoContext(ctx, "Rejected socket.io connection", "socket_id", so.ID(), "error", err)
+			return err
+		}
+		so.SetContext(principal)
+		s.connect(so)
+
+		slog.DebugContext(ctx, "Socket.io client connected", "socket_id", so.ID(), "subject", principal.Subject)
 		return nil
 	})
 
 	// Modified to accept both appID and key
 	s.Server.OnEvent("/", "subscribe_key", func(so socketio.Conn, appID, key string) {
-		log.Printf("Client %s subscribed to key: %s in app: %s", so.ID(), key, appID)
-		s.mu.Lock()
-		defer s.mu.Unlock()
-
-		// Initialize nested maps if they don't exist
-		if s.keySubs[appID] == nil {
-			s.keySubs[appID] = make(map[string]map[string]socketio.Conn)
+		ctx := eventContext()
+		if !authorizeSubscription(ctx, so, appID, key, s.authorize(so, appID, key)) {
+			return
 		}
-		if s.keySubs[appID][key] == nil {
-			s.keySubs[appID][key] = make(map[string]socketio.Conn)
-		}
-		s.keySubs[appID][key][so.ID()] = so
+		slog.DebugContext(ctx, "Socket.io client subscribed to key", "socket_id", so.ID(), "app_id", appID, "key", key)
+		s.subscribeKey(so, appID, key)
 	})
 
 	s.Server.OnEvent("/", "unsubscribe_key", func(so socketio.Conn, appID, key string) {
-		log.Printf("Client %s unsubscribed from key: %s in app: %s", so.ID(), key, appID)
-		s.mu.Lock()
-		defer s.mu.Unlock()
-		if appSubs, ok := s.keySubs[appID]; ok {
-			if clients, ok := appSubs[key]; ok {
-				delete(clients, so.ID())
-				if len(clients) == 0 {
-					delete(appSubs, key)
-				}
-				if len(appSubs) == 0 {
-					delete(s.keySubs, appID)
-				}
-			}
-		}
+		slog.DebugContext(eventContext(), "Socket.io client unsubscribed from key", "socket_id", so.ID(), "app_id", appID, "key", key)
+		s.unsubscribeKey(so, appID, key)
 	})
 
 	s.Server.OnEvent("/", "subscribe_app", func(so socketio.Conn, appID string) {
-		log.Printf("Client %s subscribed to app: %s", so.ID(), appID)
-		s.mu.Lock()
-		defer s.mu.Unlock()
-		if s.appSubs[appID] == nil {
-			s.appSubs[appID] = make(map[string]socketio.Conn)
+		ctx := eventContext()
+		if !authorizeSubscription(ctx, so, appID, "", s.authorize(so, appID, "")) {
+			return
 		}
-		s.appSubs[appID][so.ID()] = so
+		slog.DebugContext(ctx, "Socket.io client subscribed to app", "socket_id", so.ID(), "app_id", appID)
+		s.subscribeApp(so, appID)
 	})
 
 	s.Server.OnEvent("/", "unsubscribe_app", func(so socketio.Conn, appID string) {
-		log.Printf("Client %s unsubscribed from app: %s", so.ID(), appID)
-		s.mu.Lock()
-		defer s.mu.Unlock()
-		if clients, ok := s.appSubs[appID]; ok {
-			delete(clients, so.ID())
+		slog.DebugContext(eventContext(), "Socket.io client unsubscribed from app", "socket_id", so.ID(), "app_id", appID)
+		s.unsubscribeApp(so, appID)
+	})
+
+	s.Server.OnDisconnect("/", func(so socketio.Conn, reason string) {
+		// Rejected connections were never counted.
+		if subscriber(so) != nil {
+			slog.DebugContext(eventContext(), "Socket.io client disconnected", "socket_id", so.ID(), "reason", reason)
+		}
+		s.disconnect(so)
+	})
+
+	return s
+}
+
+// queryCredential returns the credential passed as the token or api_key
+// query parameter.
+func queryCredential(query url.Values) string {
+	if credential := query.Get("token"); credential != "" {
+		return credential
+	}
+	return query.Get("api_key")
+}
+
+func (s *SocketServer) connect(c conn) {
+	metrics.SocketClients.Inc()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[c.ID()] = c
+}
+
+// disconnect drops the subscriptions of c.
+func (s *SocketServer) disconnect(c conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conns[c.ID()]; !ok {
+		return
+	}
+	metrics.SocketClients.Dec()
+	delete(s.conns, c.ID())
+	// Clean up key subscriptions
+	for appID, appSubs := range s.keySubs {
+		for key, clients := range appSubs {
+			if _, ok := clients[c.ID()]; ok {
+				delete(clients, c.ID())
+				metrics.SocketSubscriptions.WithLabelValues(appID, subscriptionKey).Dec()
+			}
 			if len(clients) == 0 {
-				delete(s.appSubs, appID)
+				delete(appSubs, key)
 			}
 		}
-	})
+		if len(appSubs) == 0 {
+			delete(s.keySubs, appID)
+		}
+	}
+	// Clean up app subscriptions
+	for appID, clients := range s.appSubs {
+		if _, ok := clients[c.ID()]; ok {
+			delete(clients, c.ID())
+			metrics.SocketSubscriptions.WithLabelValues(appID, subscriptionApp).Dec()
+		}
+		if len(clients) == 0 {
+			delete(s.appSubs, appID)
+		}
+	}
+}
 
-	s.Server.OnDisconnect("/", func(so socketio.Conn, reason string) {
-		s.mu.Lock()
-		defer s.mu.Unlock()
-		// Clean up key subscriptions
-		for appID, appSubs := range s.keySubs {
-			for key, clients := range appSubs {
-				delete(clients, so.ID())
-				if len(clients) == 0 {
-					delete(appSubs, key)
-				}
+// authorize checks that c may subscribe to key in appID, or to the app when
+// key is empty, and charges the subscription to the rate limits.
+func (s *SocketServer) authorize(c conn, appID, key string) error {
+	principal := subscriber(c)
+	var err error
+	if key != "" {
+		err = principal.Authorize(appID, key, auth.ActionSubscribe)
+	} else {
+		err = principal.AuthorizeApp(appID, auth.ActionSubscribe)
+	}
+	if err != nil {
+		return err
+	}
+	return s.limiter.Allow(ratelimit.Client(principal, c.RemoteAddr().String()), appID, ratelimit.Subscribe)
+}
+
+func (s *SocketServer) subscribeKey(c conn, appID, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Initialize nested maps if they don't exist
+	if s.keySubs[appID] == nil {
+		s.keySubs[appID] = make(map[string]map[string]conn)
+	}
+	if s.keySubs[appID][key] == nil {
+		s.keySubs[appID][key] = make(map[string]conn)
+	}
+	if _, ok := s.keySubs[appID][key][c.ID()]; !ok {
+		metrics.SocketSubscriptions.WithLabelValues(appID, subscriptionKey).Inc()
+	}
+	s.keySubs[appID][key][c.ID()] = c
+}
+
+func (s *SocketServer) unsubscribeKey(c conn, appID, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if appSubs, ok := s.keySubs[appID]; ok {
+		if clients, ok := appSubs[key]; ok {
+			if _, ok := clients[c.ID()]; ok {
+				delete(clients, c.ID())
+				metrics.SocketSubscriptions.WithLabelValues(appID, subscriptionKey).Dec()
+			}
+			if len(clients) == 0 {
+				delete(appSubs, key)
 			}
 			if len(appSubs) == 0 {
 				delete(s.keySubs, appID)
 			}
 		}
-		// Clean up app subscriptions
-		for appID, clients := range s.appSubs {
-			delete(clients, so.ID())
-			if len(clients) == 0 {
-				delete(s.appSubs, appID)
+	}
+}
+
+func (s *SocketServer) subscribeApp(c conn, appID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.appSubs[appID] == nil {
+		s.appSubs[appID] = make(map[string]conn)
+	}
+	if _, ok := s.appSubs[appID][c.ID()]; !ok {
+		metrics.SocketSubscriptions.WithLabelValues(appID, subscriptionApp).Inc()
+	}
+	s.appSubs[appID][c.ID()] = c
+}
+
+func (s *SocketServer) unsubscribeApp(c conn, appID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if clients, ok := s.appSubs[appID]; ok {
+		if _, ok := clients[c.ID()]; ok {
+			delete(clients, c.ID())
+			metrics.SocketSubscriptions.WithLabelValues(appID, subscriptionApp).Dec()
+		}
+		if len(clients) == 0 {
+			delete(s.appSubs, appID)
+		}
+	}
+}
+
+// eventContext returns the context of a socket.io event, with a new
+// correlation ID for its log lines.
+func eventContext() context.Context {
+	return logging.WithRequestID(context.Background(), logging.NewRequestID())
+}
+
+func subscriber(c conn) *auth.Principal {
+	principal, _ := c.Context().(*auth.Principal)
+	return principal
+}
+
+// authorizeSubscription reports whether the subscription was allowed,
+// emitting a subscribe_error event to the client when it was not.
+func authorizeSubscription(ctx context.Context, so socketio.Conn, appID, key string, err error) bool {
+	if err != nil {
+		slog.InfoContext(ctx, "Rejected socket.io subscription", "socket_id", so.ID(), "app_id", appID, "key", key, "error", err)
+		so.Emit("subscribe_error", subscribeError(ctx, appID, key, err))
+		return false
+	}
+	return true
+}
+
+// subscribeError returns the payload telling a client its subscription was
+// rejected. Rate limited clients are told after how many seconds to retry.
+func subscribeError(ctx context.Context, appID, key string, err error) gin.H {
+	payload := gin.H{
+		"app_id":     appID,
+		"key":        key,
+		"error":      err.Error(),
+		"request_id": logging.RequestID(ctx),
+	}
+	var rateLimitErr *ratelimit.Error
+	if errors.As(err, &rateLimitErr) {
+		payload["retry_after"] = math.Ceil(rateLimitErr.RetryAfter.Seconds())
+	}
+	return payload
+}
+
+// Serve runs the socket.io server until it is closed.
+func (s *SocketServer) Serve() error {
+	s.serving.Store(true)
+	defer s.serving.Store(false)
+	return s.Server.Serve()
+}
+
+// Shutdown tells every client with a shutdown event to reconnect to another
+// node, waits until they have disconnected or ctx is done, and closes the
+// server.
+func (s *SocketServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	for _, so := range s.conns {
+		so.Emit("shutdown")
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for s.connected() > 0 {
+		select {
+		case <-ctx.Done():
+			slog.Warn("Closing socket.io connections of clients that did not reconnect", "clients", s.connected())
+			s.closeWebSockets()
+			return s.Server.Close()
+		case <-ticker.C:
+		}
+	}
+	return s.Server.Close()
+}
+
+// SocketSubscription is a live subscription of a socket.io or WebSocket
+// client.
+type SocketSubscription struct {
+	ClientID  string `json:"client_id"`
+	Subject   string `json:"subject"`
+	AppID     string `json:"app_id"`
+	Key       string `json:"key,omitempty"` // empty for app subscriptions
+	Kind      string `json:"kind"`          // key or app
+	Transport string `json:"transport"`     // socket.io or websocket
+}
+
+// Subscriptions lists the live subscriptions to the keys of appID, or of
+// every app when appID is empty, sorted by app, key and client.
+func (s *SocketServer) Subscriptions(appID string) []SocketSubscription {
+	s.mu.Lock()
+	subscriptions := []SocketSubscription{}
+	for subAppID, keys := range s.keySubs {
+		if appID != "" && subAppID != appID {
+			continue
+		}
+		for key, clients := range keys {
+			for _, so := range clients {
+				subscriptions = append(subscriptions, SocketSubscription{
+					ClientID:  so.ID(),
+					Subject:   subscriber(so).Subject,
+					AppID:     subAppID,
+					Key:       key,
+					Kind:      subscriptionKey,
+					Transport: transport(so),
+				})
 			}
 		}
+	}
+	for subAppID, clients := range s.appSubs {
+		if appID != "" && subAppID != appID {
+			continue
+		}
+		for _, so := range clients {
+			subscriptions = append(subscriptions, SocketSubscription{
+				ClientID:  so.ID(),
+				Subject:   subscriber(so).Subject,
+				AppID:     subAppID,
+				Kind:      subscriptionApp,
+				Transport: transport(so),
+			})
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		a, b := subscriptions[i], subscriptions[j]
+		if a.AppID != b.AppID {
+			return a.AppID < b.AppID
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.ClientID < b.ClientID
 	})
+	return subscriptions
+}
 
-	return s
+func transport(c conn) string {
+	if _, ok := c.(*webSocketConn); ok {
+		return "websocket"
+	}
+	return "socket.io"
+}
+
+// SubscriberCounts counts the socket.io and WebSocket subscribers of an app.
+type SubscriberCounts struct {
+	Clients          int `json:"clients"` // clients subscribed to the app or any of its keys
+	KeySubscriptions int `json:"key_subscriptions"`
+	AppSubscriptions int `json:"app_subscriptions"`
+}
+
+func (s *SocketServer) Subscribers(appID string) SubscriberCounts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var counts SubscriberCounts
+	clients := make(map[string]struct{})
+	for _, subs := range s.keySubs[appID] {
+		counts.KeySubscriptions += len(subs)
+		for clientID := range subs {
+			clients[clientID] = struct{}{}
+		}
+	}
+	counts.AppSubscriptions = len(s.appSubs[appID])
+	for clientID := range s.appSubs[appID] {
+		clients[clientID] = struct{}{}
+	}
+	counts.Clients = len(clients)
+	return counts
+}
+
+func (s *SocketServer) connected() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Check reports whether the server is serving connections.
+func (s *SocketServer) Check(ctx context.Context) error {
+	if !s.serving.Load() {
+		return errors.New("socket.io server is not serving")
+	}
+	return nil
+}
+
+// HandleKeyChange emits a key change to its subscribers under a span, so
+// traces show how long delivery to socket.io clients took.
+func (s *SocketServer) HandleKeyChange(ctx context.Context, keyChange KeyChangeMessage) {
+	_, span := tracing.Tracer.Start(ctx, "socket.io emit",
+		trace.WithAttributes(
+			attribute.String("kvstored.change_type", keyChange.Type),
+			attribute.String("kvstored.app_id", keyChange.AppID),
+			attribute.String("kvstored.key", keyChange.Key),
+		),
+	)
+	defer span.End()
+	slog.DebugContext(ctx, "Emitting key change to socket.io subscribers", "type", keyChange.Type, "app_id", keyChange.AppID, "key", keyChange.Key)
+
+	switch keyChange.Type {
+	case "set":
+		if keyChange.Value != nil {
+			s.NotifyKeySet(*keyChange.Value)
+		}
+	case "update":
+		if keyChange.Value != nil {
+			s.NotifyKeyUpdated(*keyChange.Value)
+		}
+	case "delete":
+		s.NotifyKeyDeleted(keyChange.AppID, keyChange.Key)
+	}
 }
 
 func (s *SocketServer) NotifyKeySet(keyValue entity.KeyValue) {
@@ -123,9 +485,12 @@ func (s *SocketServer) NotifyKeySet(keyValue entity.KeyValue) {
 		}
 	}
 
-	// Notify app subscribers
+	// Notify app subscribers allowed to see the key
 	if clients, ok := s.appSubs[keyValue.AppID]; ok {
 		for _, so := range clients {
+			if !subscriber(so).Allows(keyValue.AppID, keyValue.Key, auth.ActionSubscribe) {
+				continue
+			}
 			so.Emit("key_set", keyValue)
 		}
 	}
@@ -144,9 +509,12 @@ func (s *SocketServer) NotifyKeyUpdated(keyValue entity.KeyValue) {
 		}
 	}
 
-	// Notify app subscribers
+	// Notify app subscribers allowed to see the key
 	if clients, ok := s.appSubs[keyValue.AppID]; ok {
 		for _, so := range clients {
+			if !subscriber(so).Allows(keyValue.AppID, keyValue.Key, auth.ActionSubscribe) {
+				continue
+			}
 			so.Emit("key_updated", keyValue)
 		}
 	}
@@ -168,9 +536,12 @@ func (s *SocketServer) NotifyKeyDeleted(appID, key string) {
 		}
 	}
 
-	// Notify app subscribers
+	// Notify app subscribers allowed to see the key
 	if clients, ok := s.appSubs[appID]; ok {
 		for _, so := range clients {
+			if !subscriber(so).Allows(appID, key, auth.ActionSubscribe) {
+				continue
+			}
 			so.Emit("key_deleted", gin.H{
 				"app_id": appID,
 				"key":    key,
@@ -0,0 +1,301 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/logging"
+)
+
+const (
+	// webSocketBuffer is how many messages a WebSocket client may fall
+	// behind before it is disconnected.
+	webSocketBuffer = 64
+	// webSocketWriteTimeout bounds how long a write to a client may block.
+	webSocketWriteTimeout = 10 * time.Second
+	// webSocketMaxMessage is the largest message accepted from clients.
+	webSocketMaxMessage = 4096
+
+	// engine.io's defaults, used where the socket config leaves them zero.
+	defaultPingInterval = 20 * time.Second
+	defaultPingTimeout  = time.Minute
+)
+
+var webSocketUpgrader = websocket.Upgrader{
+	// Clients authenticate with a credential rather than cookies, so pages
+	// on other origins gain nothing from connecting.
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+var webSocketIDs atomic.Uint64
+
+// webSocketRequest is a message sent by a WebSocket client.
+type webSocketRequest struct {
+	Type  string          `json:"type"`
+	ID    json.RawMessage `json:"id,omitempty"`
+	AppID string          `json:"app_id"`
+	Key   string          `json:"key"`
+}
+
+// webSocketMessage is a message sent to a WebSocket client. ID echoes the
+// ID of the request it answers.
+type webSocketMessage struct {
+	Type string          `json:"type"`
+	ID   json.RawMessage `json:"id,omitempty"`
+	Data any             `json:"data,omitempty"`
+}
+
+// ServeWebSocket serves the plain WebSocket protocol, for clients without a
+// socket.io client. Connections authenticate like socket.io ones, with the
+// Authorization or X-API-Key header, or the token or api_key query
+// parameter, and share their subscriptions, rate limits and permissions.
+//
+// Every message is a JSON text frame with a type. Clients send:
+//
+//	{"type": "subscribe_key", "id": 1, "app_id": "app", "key": "key"}
+//	{"type": "unsubscribe_key", "id": 2, "app_id": "app", "key": "key"}
+//	{"type": "subscribe_app", "id": 3, "app_id": "app"}
+//	{"type": "unsubscribe_app", "id": 4, "app_id": "app"}
+//	{"type": "ping", "id": 5}
+//
+// The optional id, any JSON value, is echoed in the reply: an ack, a pong,
+// or an error carrying the payload of socket.io's subscribe_error event:
+//
+//	{"type": "ack", "id": 1, "data": {"app_id": "app", "key": "key"}}
+//	{"type": "pong", "id": 5}
+//	{"type": "error", "id": 1, "data": {"app_id": "app", "key": "key", "error": "...", "request_id": "...", "retry_after": 2}}
+//
+// Changes arrive as key_set, key_updated and key_deleted messages whose data
+// is the payload of the socket.io event of the same name, and a shutdown
+// message asks the client to reconnect to another node:
+//
+//	{"type": "key_set", "data": {"app_id": "app", "key": "key", "value": "...", ...}}
+//	{"type": "key_deleted", "data": {"app_id": "app", "key": "key"}}
+//	{"type": "shutdown"}
+//
+// The server sends WebSocket pings at the socket ping interval and closes
+// connections that send nothing, not even a pong, within the ping timeout
+// after that. Clients that fall too far behind to be sent a change are
+// closed with status 1013 (try again later), as they would otherwise miss
+// it; they should reconnect and read the keys they watch again.
+func (s *SocketServer) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	credential := auth.Credential(r.Header)
+	if credential == "" {
+		credential = queryCredential(r.URL.Query())
+	}
+	ctx := eventContext()
+	principal, err := s.authenticator.Authenticate(ctx, credential)
+	if err != nil {
+		slog.InfoContext(ctx, "Rejected WebSocket connection", "error", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	ws, err := webSocketUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has responded with the error.
+		return
+	}
+
+	c := &webSocketConn{
+		id:        "ws-" + strconv.FormatUint(webSocketIDs.Add(1), 10),
+		principal: principal,
+		ws:        ws,
+		send:      make(chan webSocketMessage, webSocketBuffer),
+		closed:    make(chan struct{}),
+	}
+	s.connect(c)
+	slog.DebugContext(ctx, "WebSocket client connected", "client_id", c.id, "subject", principal.Subject)
+
+	pingInterval, pingTimeout := s.cfg.PingInterval, s.cfg.PingTimeout
+	if pingInterval <= 0 {
+		pingInterval = defaultPingInterval
+	}
+	if pingTimeout <= 0 {
+		pingTimeout = defaultPingTimeout
+	}
+	go c.write(pingInterval)
+	s.read(c, pingInterval+pingTimeout)
+
+	c.Close()
+	s.disconnect(c)
+	slog.DebugContext(eventContext(), "WebSocket client disconnected", "client_id", c.id)
+}
+
+// read handles the messages of c until it disconnects or sends nothing for
+// longer than timeout.
+func (s *SocketServer) read(c *webSocketConn, timeout time.Duration) {
+	c.ws.SetReadLimit(webSocketMaxMessage)
+	c.ws.SetReadDeadline(time.Now().Add(timeout))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(timeout))
+	})
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.ws.SetReadDeadline(time.Now().Add(timeout))
+
+		ctx := eventContext()
+		var req webSocketRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.queue(webSocketMessage{Type: "error", Data: gin.H{
+				"error":      "invalid message: " + err.Error(),
+				"request_id": logging.RequestID(ctx),
+			}})
+			continue
+		}
+
+		ack := webSocketMessage{Type: "ack", ID: req.ID, Data: gin.H{"app_id": req.AppID, "key": req.Key}}
+		switch req.Type {
+		case "subscribe_key", "subscribe_app":
+			key := req.Key
+			if req.Type == "subscribe_app" {
+				key = ""
+				ack.Data = gin.H{"app_id": req.AppID}
+			}
+			if err := s.authorize(c, req.AppID, key); err != nil {
+				slog.InfoContext(ctx, "Rejected WebSocket subscription", "client_id", c.id, "app_id", req.AppID, "key", key, "error", err)
+				c.queue(webSocketMessage{Type: "error", ID: req.ID, Data: subscribeError(ctx, req.AppID, key, err)})
+				continue
+			}
+			if key != "" {
+				slog.DebugContext(ctx, "WebSocket client subscribed to key", "client_id", c.id, "app_id", req.AppID, "key", key)
+				s.subscribeKey(c, req.AppID, key)
+			} else {
+				slog.DebugContext(ctx, "WebSocket client subscribed to app", "client_id", c.id, "app_id", req.AppID)
+				s.subscribeApp(c, req.AppID)
+			}
+		case "unsubscribe_key":
+			slog.DebugContext(ctx, "WebSocket client unsubscribed from key", "client_id", c.id, "app_id", req.AppID, "key", req.Key)
+			s.unsubscribeKey(c, req.AppID, req.Key)
+		case "unsubscribe_app":
+			slog.DebugContext(ctx, "WebSocket client unsubscribed from app", "client_id", c.id, "app_id", req.AppID)
+			s.unsubscribeApp(c, req.AppID)
+			ack.Data = gin.H{"app_id": req.AppID}
+		case "ping":
+			ack = webSocketMessage{Type: "pong", ID: req.ID}
+		default:
+			c.queue(webSocketMessage{Type: "error", ID: req.ID, Data: gin.H{
+				"error":      "unknown message type " + strconv.Quote(req.Type),
+				"request_id": logging.RequestID(ctx),
+			}})
+			continue
+		}
+		c.queue(ack)
+	}
+}
+
+// closeWebSockets closes the connections of WebSocket clients.
+func (s *SocketServer) closeWebSockets() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		if ws, ok := c.(*webSocketConn); ok {
+			ws.Close()
+		}
+	}
+}
+
+// webSocketConn is a client of ServeWebSocket. Messages are queued and
+// written by one goroutine, as a WebSocket connection takes one writer at
+// a time.
+type webSocketConn struct {
+	id        string
+	principal *auth.Principal
+	ws        *websocket.Conn
+	send      chan webSocketMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+	// closeCode and closeReason are sent in the close frame, and set before
+	// closed is closed.
+	closeCode   int
+	closeReason string
+}
+
+func (c *webSocketConn) ID() string {
+	return c.id
+}
+
+func (c *webSocketConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *webSocketConn) Context() interface{} {
+	return c.principal
+}
+
+func (c *webSocketConn) Emit(event string, v ...interface{}) {
+	msg := webSocketMessage{Type: event}
+	if len(v) > 0 {
+		msg.Data = v[0]
+	}
+	c.queue(msg)
+}
+
+// Close ends the connection, dropping the messages not written yet.
+func (c *webSocketConn) Close() error {
+	c.closeWith(websocket.CloseGoingAway, "")
+	return nil
+}
+
+func (c *webSocketConn) closeWith(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode, c.closeReason = code, reason
+		close(c.closed)
+	})
+}
+
+// queue queues msg for writing. When the client has fallen too far behind,
+// replies are dropped, but a client that would miss a change is
+// disconnected instead.
+func (c *webSocketConn) queue(msg webSocketMessage) {
+	select {
+	case <-c.closed:
+	case c.send <- msg:
+	default:
+		switch msg.Type {
+		case "ack", "pong", "error":
+			slog.Warn("Dropping message for a slow WebSocket client", "client_id", c.id, "type", msg.Type)
+		default:
+			slog.Warn("Disconnecting a slow WebSocket client", "client_id", c.id, "type", msg.Type)
+			c.closeWith(websocket.CloseTryAgainLater, "too slow to keep up with changes")
+		}
+	}
+}
+
+// write writes queued messages and pings every pingInterval until the
+// connection is closed or a write fails.
+func (c *webSocketConn) write(pingInterval time.Duration) {
+	defer c.ws.Close()
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case msg := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+			if err := c.ws.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(webSocketWriteTimeout)); err != nil {
+				return
+			}
+		case <-c.closed:
+			c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeReason), time.Now().Add(webSocketWriteTimeout))
+			return
+		}
+	}
+}
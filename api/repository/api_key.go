@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/keanutaufan/kvstored/api/db"
+	"github.com/keanutaufan/kvstored/api/entity"
+)
+
+// ErrAPIKeyNotFound is returned when no API key has the given ID.
+var ErrAPIKeyNotFound = NewError(ErrNotFound, "API key not found")
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, apiKey entity.APIKey) error
+	Get(ctx context.Context, id string) (entity.APIKey, error)
+	List(ctx context.Context) ([]entity.APIKey, error)
+	Revoke(ctx context.Context, id string, revokedAt time.Time) error
+}
+
+type apiKeyRepository struct {
+	client *db.CassandraClient
+}
+
+func NewAPIKeyRepository(client *db.CassandraClient) APIKeyRepository {
+	return &apiKeyRepository{client: client}
+}
+
+func (r *apiKeyRepository) Create(ctx context.Context, apiKey entity.APIKey) error {
+	err := r.client.Session.Query(`
+        INSERT INTO api_keys (id, name, key_hash, scopes, roles, created_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, apiKey.ID, apiKey.Name, apiKey.Hash, apiKey.Scopes, apiKey.Roles, apiKey.CreatedAt).WithContext(ctx).Exec()
+	return translateError(err)
+}
+
+func (r *apiKeyRepository) Get(ctx context.Context, id string) (entity.APIKey, error) {
+	var apiKey entity.APIKey
+	err := r.client.Session.Query(`
+        SELECT id, name, key_hash, scopes, roles, created_at, revoked_at FROM api_keys
+        WHERE id = ?
+    `, id).WithContext(ctx).Scan(&apiKey.ID, &apiKey.Name, &apiKey.Hash, &apiKey.Scopes, &apiKey.Roles, &apiKey.CreatedAt, &apiKey.RevokedAt)
+
+	if errors.Is(err, gocql.ErrNotFound) {
+		return entity.APIKey{}, ErrAPIKeyNotFound
+	} else if err != nil {
+		return entity.APIKey{}, translateError(err)
+	}
+	return apiKey, nil
+}
+
+func (r *apiKeyRepository) List(ctx context.Context) ([]entity.APIKey, error) {
+	var apiKeys []entity.APIKey
+	iter := r.client.Session.Query(`
+        SELECT id, name, key_hash, scopes, roles, created_at, revoked_at FROM api_keys
+    `).WithContext(ctx).Iter()
+
+	for {
+		var apiKey entity.APIKey
+		if !iter.Scan(&apiKey.ID, &apiKey.Name, &apiKey.Hash, &apiKey.Scopes, &apiKey.Roles, &apiKey.CreatedAt, &apiKey.RevokedAt) {
+			break
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, translateError(err)
+	}
+	return apiKeys, nil
+}
+
+// Revoke marks the key as revoked. The row is kept so the key stays visible
+// when listing keys.
+func (r *apiKeyRepository) Revoke(ctx context.Context, id string, revokedAt time.Time) error {
+	applied, err := r.client.Session.Query(`
+        UPDATE api_keys SET revoked_at = ?
+        WHERE id = ?
+        IF EXISTS
+    `, revokedAt, id).WithContext(ctx).ScanCAS()
+	if err != nil {
+		return translateError(err)
+	}
+	if !applied {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
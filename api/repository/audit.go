@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/keanutaufan/kvstored/api/db"
+	"github.com/keanutaufan/kvstored/api/entity"
+)
+
+// auditDayFormat names the daily partitions of an app's audit log.
+const auditDayFormat = "2006-01-02"
+
+type AuditRepository interface {
+	// Append stores a record in the partition of its app and UTC day. It
+	// sets the record's ID.
+	Append(ctx context.Context, record *entity.AuditRecord) error
+	// List returns up to limit records of appID made on day, which is
+	// given in UTC, between from and to, newest first. A non-empty key
+	// only returns records of that key.
+	List(ctx context.Context, appID string, day, from, to time.Time, key string, limit int) ([]entity.AuditRecord, error)
+}
+
+type auditRepository struct {
+	client *db.CassandraClient
+}
+
+func NewAuditRepository(client *db.CassandraClient) AuditRepository {
+	return &auditRepository{client: client}
+}
+
+func (r *auditRepository) Append(ctx context.Context, record *entity.AuditRecord) error {
+	id := gocql.UUIDFromTime(record.Time)
+	err := r.client.Session.Query(`
+        INSERT INTO audit_log (app_id, day, id, key, action, actor, ip, user_agent, old_hash, new_hash, request_id)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `, record.AppID, record.Time.UTC().Format(auditDayFormat), id, record.Key, record.Action, record.Actor,
+		record.IP, record.UserAgent, record.OldHash, record.NewHash, record.RequestID).WithContext(ctx).Exec()
+	if err != nil {
+		return translateError(err)
+	}
+
+	record.ID = id.String()
+	return nil
+}
+
+func (r *auditRepository) List(ctx context.Context, appID string, day, from, to time.Time, key string, limit int) ([]entity.AuditRecord, error) {
+	records := []entity.AuditRecord{}
+	iter := r.client.Session.Query(`
+        SELECT id, key, action, actor, ip, user_agent, old_hash, new_hash, request_id FROM audit_log
+        WHERE app_id = ? AND day = ? AND id >= minTimeuuid(?) AND id <= maxTimeuuid(?)
+    `, appID, day.UTC().Format(auditDayFormat), from, to).WithContext(ctx).Iter()
+
+	for len(records) < limit {
+		var id gocql.UUID
+		record := entity.AuditRecord{AppID: appID}
+		if !iter.Scan(&id, &record.Key, &record.Action, &record.Actor, &record.IP, &record.UserAgent,
+			&record.OldHash, &record.NewHash, &record.RequestID) {
+			break
+		}
+		if key != "" && record.Key != key {
+			continue
+		}
+		record.ID = id.String()
+		record.Time = id.Time()
+		records = append(records, record)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, translateError(err)
+	}
+	return records, nil
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gocql/gocql"
+)
+
+// Error kinds shared by every layer. Match them with errors.Is; the concrete
+// errors returned carry a more specific message.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrValidation  = errors.New("validation failed")
+	ErrUnavailable = errors.New("storage unavailable")
+	ErrTimeout     = errors.New("storage timeout")
+)
+
+var (
+	// ErrKeyNotFound is returned when the addressed key does not exist.
+	ErrKeyNotFound = NewError(ErrNotFound, "key not found for the given app")
+	// ErrKeyExists is returned by Create when the key is already set.
+	ErrKeyExists = NewError(ErrConflict, "key already exists")
+	// ErrVersionMismatch is returned by the CompareAnd* methods when the
+	// stored value no longer carries the expected version.
+	ErrVersionMismatch = NewError(ErrConflict, "version does not match the current value")
+)
+
+// Error is an error of one of the kinds above with a specific message and,
+// for driver failures, the underlying cause.
+type Error struct {
+	Kind    error
+	Message string
+	Cause   error
+}
+
+func NewError(kind error, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Message + ": " + e.Cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Cause != nil {
+		return []error{e.Kind, e.Cause}
+	}
+	return []error{e.Kind}
+}
+
+// translateError classifies gocql errors into the kinds above so callers
+// never have to inspect driver types. Unknown errors are returned unchanged.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, gocql.ErrNotFound):
+		return ErrKeyNotFound
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, gocql.ErrTimeoutNoResponse),
+		errors.Is(err, gocql.ErrTooManyTimeouts):
+		return &Error{Kind: ErrTimeout, Message: "cassandra query timed out", Cause: err}
+	case errors.Is(err, gocql.ErrNoConnections),
+		errors.Is(err, gocql.ErrConnectionClosed),
+		errors.Is(err, gocql.ErrSessionClosed),
+		errors.Is(err, gocql.ErrNoStreams),
+		errors.Is(err, gocql.ErrUnavailable):
+		return &Error{Kind: ErrUnavailable, Message: "cassandra is unavailable", Cause: err}
+	}
+
+	var reqErr gocql.RequestError
+	if errors.As(err, &reqErr) {
+		switch reqErr.Code() {
+		case gocql.ErrCodeWriteTimeout, gocql.ErrCodeReadTimeout, gocql.ErrCodeCASWriteUnknown:
+			return &Error{Kind: ErrTimeout, Message: "cassandra query timed out", Cause: err}
+		case gocql.ErrCodeUnavailable, gocql.ErrCodeOverloaded, gocql.ErrCodeBootstrapping:
+			return &Error{Kind: ErrUnavailable, Message: "cassandra is unavailable", Cause: err}
+		}
+	}
+
+	return err
+}
@@ -2,20 +2,26 @@ package repository
 
 import (
 	"context"
-	"errors"
 	"strings"
+	"time"
 
 	"github.com/gocql/gocql"
 	"github.com/keanutaufan/kvstored/api/db"
 	"github.com/keanutaufan/kvstored/api/entity"
 )
 
+var errEmptyAppOrKey = NewError(ErrValidation, "app_id and key cannot be empty")
+
 type KeyValueRepository interface {
 	GetAll(ctx context.Context, appID string) ([]entity.KeyValue, error)
 	Set(ctx context.Context, keyValue entity.KeyValue) error
 	Get(ctx context.Context, appID, key string) (entity.KeyValue, error)
 	Update(ctx context.Context, keyValue entity.KeyValue) error
 	Delete(ctx context.Context, appID, key string) error
+	CompareAndSet(ctx context.Context, keyValue entity.KeyValue, version int64) error
+	CompareAndDelete(ctx context.Context, appID, key string, version int64) error
+	Create(ctx context.Context, keyValue entity.KeyValue) error
+	Stats(ctx context.Context, appID string) (entity.AppStats, error)
 }
 
 type keyValueRepository struct {
@@ -30,18 +36,18 @@ func NewKeyValueRepository(client *db.CassandraClient) KeyValueRepository {
 func (r *keyValueRepository) GetAll(ctx context.Context, appID string) ([]entity.KeyValue, error) {
 	var keyValues []entity.KeyValue
 	iter := r.client.Session.Query(`
-        SELECT app_id, key, value, created_at 
-        FROM kv_store_app.key_values 
+        SELECT app_id, key, value, content_type, version, created_at, TTL(value) 
+        FROM key_values 
         WHERE app_id = ?
     `, appID).WithContext(ctx).Iter()
 
 	var kv entity.KeyValue
-	for iter.Scan(&kv.AppID, &kv.Key, &kv.Value, &kv.CreatedAt) {
+	for iter.Scan(&kv.AppID, &kv.Key, &kv.Value, &kv.ContentType, &kv.Version, &kv.CreatedAt, &kv.TTL) {
 		keyValues = append(keyValues, kv)
 	}
 
 	if err := iter.Close(); err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	return keyValues, nil
@@ -49,55 +55,168 @@ func (r *keyValueRepository) GetAll(ctx context.Context, appID string) ([]entity
 
 func (r *keyValueRepository) Set(ctx context.Context, keyValue entity.KeyValue) error {
 	if strings.TrimSpace(keyValue.AppID) == "" || strings.TrimSpace(keyValue.Key) == "" {
-		return errors.New("app_id and key cannot be empty")
+		return errEmptyAppOrKey
 	}
 
-	return r.client.Session.Query(`
-        INSERT INTO kv_store_app.key_values (app_id, key, value, created_at) 
-        VALUES (?, ?, ?, ?)
-    `, keyValue.AppID, keyValue.Key, keyValue.Value, keyValue.CreatedAt).WithContext(ctx).Exec()
+	err := r.client.Session.Query(`
+        INSERT INTO key_values (app_id, key, value, content_type, version, created_at) 
+        VALUES (?, ?, ?, ?, ?, ?)
+        USING TTL ?
+    `, keyValue.AppID, keyValue.Key, keyValue.Value, keyValue.ContentType, keyValue.Version, keyValue.CreatedAt, keyValue.TTL).WithContext(ctx).Exec()
+	return translateError(err)
 }
 
 func (r *keyValueRepository) Get(ctx context.Context, appID, key string) (entity.KeyValue, error) {
 	var keyValue entity.KeyValue
 	err := r.client.Session.Query(`
-		SELECT app_id, key, value, created_at FROM kv_store_app.key_values 
+		SELECT app_id, key, value, content_type, version, created_at, TTL(value) FROM key_values 
 		WHERE app_id = ? AND key = ?
-	`, appID, key).WithContext(ctx).Scan(&keyValue.AppID, &keyValue.Key, &keyValue.Value, &keyValue.CreatedAt)
+	`, appID, key).WithContext(ctx).Scan(&keyValue.AppID, &keyValue.Key, &keyValue.Value, &keyValue.ContentType, &keyValue.Version, &keyValue.CreatedAt, &keyValue.TTL)
 
-	if err == gocql.ErrNotFound {
-		return entity.KeyValue{}, errors.New("key not found for the given app")
+	if err != nil {
+		return entity.KeyValue{}, translateError(err)
 	}
-	return keyValue, err
+	return keyValue, nil
 }
 
 func (r *keyValueRepository) Update(ctx context.Context, keyValue entity.KeyValue) error {
-	if strings.TrimSpace(keyValue.AppID) == "" || strings.TrimSpace(keyValue.Value) == "" {
-		return errors.New("app_id and key cannot be empty")
+	if strings.TrimSpace(keyValue.AppID) == "" || strings.TrimSpace(keyValue.Key) == "" {
+		return errEmptyAppOrKey
 	}
 
-	var existing string
+	var createdAt time.Time
 	err := r.client.Session.Query(`
-        SELECT value FROM kv_store_app.key_values 
+        SELECT created_at FROM key_values 
         WHERE app_id = ? AND key = ?
-    `, keyValue.AppID, keyValue.Key).WithContext(ctx).Scan(&existing)
+    `, keyValue.AppID, keyValue.Key).WithContext(ctx).Scan(&createdAt)
 
-	if err == gocql.ErrNotFound {
-		return errors.New("key not found for the given app")
-	} else if err != nil {
-		return err
+	if err != nil {
+		return translateError(err)
 	}
 
-	return r.client.Session.Query(`
-        UPDATE kv_store_app.key_values 
-        SET value = ?
+	// created_at is rewritten so the whole row shares the new TTL.
+	err = r.client.Session.Query(`
+        UPDATE key_values 
+        USING TTL ?
+        SET value = ?, content_type = ?, version = ?, created_at = ?
         WHERE app_id = ? AND key = ?
-    `, keyValue.Value, keyValue.AppID, keyValue.Key).WithContext(ctx).Exec()
+    `, keyValue.TTL, keyValue.Value, keyValue.ContentType, keyValue.Version, createdAt, keyValue.AppID, keyValue.Key).WithContext(ctx).Exec()
+	return translateError(err)
 }
 
 func (r *keyValueRepository) Delete(ctx context.Context, appID, key string) error {
-	return r.client.Session.Query(`
-        DELETE FROM kv_store_app.key_values 
+	err := r.client.Session.Query(`
+        DELETE FROM key_values 
         WHERE app_id = ? AND key = ?
     `, appID, key).WithContext(ctx).Exec()
+	return translateError(err)
+}
+
+// CompareAndSet overwrites the value only if the stored version still equals
+// version, using a lightweight transaction so concurrent writers cannot both
+// succeed.
+func (r *keyValueRepository) CompareAndSet(ctx context.Context, keyValue entity.KeyValue, version int64) error {
+	if strings.TrimSpace(keyValue.AppID) == "" || strings.TrimSpace(keyValue.Key) == "" {
+		return errEmptyAppOrKey
+	}
+
+	return r.applyIfVersion(r.client.Session.Query(`
+        UPDATE key_values
+        USING TTL ?
+        SET value = ?, content_type = ?, version = ?, created_at = ?
+        WHERE app_id = ? AND key = ?
+        IF version = ?
+    `, keyValue.TTL, keyValue.Value, keyValue.ContentType, keyValue.Version, keyValue.CreatedAt,
+		keyValue.AppID, keyValue.Key, storedVersion(version)).WithContext(ctx))
+}
+
+func (r *keyValueRepository) CompareAndDelete(ctx context.Context, appID, key string, version int64) error {
+	return r.applyIfVersion(r.client.Session.Query(`
+        DELETE FROM key_values
+        WHERE app_id = ? AND key = ?
+        IF version = ?
+    `, appID, key, storedVersion(version)).WithContext(ctx))
+}
+
+// Create inserts the value only if the key does not exist yet.
+func (r *keyValueRepository) Create(ctx context.Context, keyValue entity.KeyValue) error {
+	if strings.TrimSpace(keyValue.AppID) == "" || strings.TrimSpace(keyValue.Key) == "" {
+		return errEmptyAppOrKey
+	}
+
+	applied, err := r.client.Session.Query(`
+        INSERT INTO key_values (app_id, key, value, content_type, version, created_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+        IF NOT EXISTS
+        USING TTL ?
+    `, keyValue.AppID, keyValue.Key, keyValue.Value, keyValue.ContentType, keyValue.Version, keyValue.CreatedAt,
+		keyValue.TTL).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return translateError(err)
+	}
+	if !applied {
+		return ErrKeyExists
+	}
+	return nil
+}
+
+// applyIfVersion executes a conditional query and tells a missing row apart
+// from a version mismatch: Cassandra only returns the current columns when
+// the row exists.
+func (r *keyValueRepository) applyIfVersion(query *gocql.Query) error {
+	current := make(map[string]interface{})
+	applied, err := query.MapScanCAS(current)
+	if err != nil {
+		return translateError(err)
+	}
+	if applied {
+		return nil
+	}
+	if _, exists := current["version"]; !exists {
+		return ErrKeyNotFound
+	}
+	return ErrVersionMismatch
+}
+
+// storedVersion maps version 0 to null so rows written before versions were
+// introduced can still be matched.
+func storedVersion(version int64) interface{} {
+	if version == 0 {
+		return nil
+	}
+	return version
+}
+
+// Stats reads every value of the app, so it costs as much as GetAll. The
+// last write time is the newest write time of a value still stored.
+func (r *keyValueRepository) Stats(ctx context.Context, appID string) (entity.AppStats, error) {
+	stats := entity.AppStats{AppID: appID}
+	iter := r.client.Session.Query(`
+        SELECT key, value, WRITETIME(value)
+        FROM key_values
+        WHERE app_id = ?
+    `, appID).WithContext(ctx).Iter()
+
+	var key, value string
+	var writeTime int64 // microseconds since the epoch
+	var lastWrite int64
+	for iter.Scan(&key, &value, &writeTime) {
+		stats.Keys++
+		stats.TotalValueBytes += int64(len(value))
+		if int64(len(value)) > stats.LargestValueBytes || stats.LargestValueKey == "" {
+			stats.LargestValueBytes = int64(len(value))
+			stats.LargestValueKey = key
+		}
+		lastWrite = max(lastWrite, writeTime)
+	}
+
+	if err := iter.Close(); err != nil {
+		return entity.AppStats{}, translateError(err)
+	}
+	if stats.Keys > 0 {
+		lastWriteAt := time.UnixMicro(lastWrite).UTC()
+		stats.LastWriteAt = &lastWriteAt
+	}
+
+	return stats, nil
 }
@@ -0,0 +1,42 @@
+package repository_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keanutaufan/kvstored/api/config"
+	"github.com/keanutaufan/kvstored/api/db"
+	"github.com/keanutaufan/kvstored/api/repository"
+	"github.com/keanutaufan/kvstored/api/repository/repositorytest"
+)
+
+// TestKeyValueRepositoryConformance runs the conformance suite against the
+// Cassandra cluster named by CASSANDRA_HOSTS, CASSANDRA_KEYSPACE and
+// CASSANDRA_CONSISTENCY, or the local defaults, whose keyspace must already
+// be migrated. It is skipped when the cluster cannot be reached.
+func TestKeyValueRepositoryConformance(t *testing.T) {
+	cfg := config.Default().Cassandra
+	cfg.ConnectTimeout = 2 * time.Second
+	if hosts := os.Getenv("CASSANDRA_HOSTS"); hosts != "" {
+		cfg.Hosts = strings.Split(hosts, ",")
+	}
+	if keyspace := os.Getenv("CASSANDRA_KEYSPACE"); keyspace != "" {
+		cfg.Keyspace = keyspace
+	}
+	if consistency := os.Getenv("CASSANDRA_CONSISTENCY"); consistency != "" {
+		cfg.Consistency = consistency
+	}
+
+	client, err := db.NewCassandraClient(cfg)
+	if err != nil {
+		t.Skipf("Cassandra is unavailable: %v", err)
+	}
+	t.Cleanup(client.Session.Close)
+
+	repo := repository.NewKeyValueRepository(client)
+	repositorytest.Run(t, func(t *testing.T) repository.KeyValueRepository {
+		return repo
+	})
+}
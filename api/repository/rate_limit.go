@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gocql/gocql"
+	"github.com/keanutaufan/kvstored/api/db"
+)
+
+// RateLimitRepository keeps request counters of fixed rate limit windows,
+// shared by every node.
+type RateLimitRepository interface {
+	// Add increments the counter of a window by n and returns its new
+	// value.
+	Add(ctx context.Context, bucket string, window int64, n int64) (int64, error)
+	// Get returns the counter of a window, zero if it was never incremented.
+	Get(ctx context.Context, bucket string, window int64) (int64, error)
+	Delete(ctx context.Context, bucket string, window int64) error
+}
+
+type rateLimitRepository struct {
+	client *db.CassandraClient
+}
+
+func NewRateLimitRepository(client *db.CassandraClient) RateLimitRepository {
+	return &rateLimitRepository{client: client}
+}
+
+func (r *rateLimitRepository) Add(ctx context.Context, bucket string, window int64, n int64) (int64, error) {
+	err := r.client.Session.Query(`
+        UPDATE rate_limits SET count = count + ?
+        WHERE bucket = ? AND window_start = ?
+    `, n, bucket, window).WithContext(ctx).Exec()
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return r.Get(ctx, bucket, window)
+}
+
+func (r *rateLimitRepository) Get(ctx context.Context, bucket string, window int64) (int64, error) {
+	var count int64
+	err := r.client.Session.Query(`
+        SELECT count FROM rate_limits
+        WHERE bucket = ? AND window_start = ?
+    `, bucket, window).WithContext(ctx).Scan(&count)
+
+	if errors.Is(err, gocql.ErrNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, translateError(err)
+	}
+	return count, nil
+}
+
+func (r *rateLimitRepository) Delete(ctx context.Context, bucket string, window int64) error {
+	err := r.client.Session.Query(`
+        DELETE FROM rate_limits
+        WHERE bucket = ? AND window_start = ?
+    `, bucket, window).WithContext(ctx).Exec()
+	return translateError(err)
+}
@@ -0,0 +1,675 @@
+// Package repositorytest provides a conformance suite that any
+// repository.KeyValueRepository implementation can run from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		repositorytest.Run(t, func(t *testing.T) repository.KeyValueRepository {
+//			return mybackend.New(...)
+//		})
+//	}
+//
+// Every subtest works in its own freshly generated app ID, so the suite can
+// be pointed at a shared Cassandra cluster without tests interfering.
+package repositorytest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/repository"
+)
+
+// Factory returns the repository under test. It is called once per subtest.
+type Factory func(t *testing.T) repository.KeyValueRepository
+
+// Run executes the full conformance suite against the repositories returned
+// by newRepository.
+func Run(t *testing.T, newRepository Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo repository.KeyValueRepository)
+	}{
+		{"SetThenGet", testSetThenGet},
+		{"SetOverwrites", testSetOverwrites},
+		{"GetMissing", testGetMissing},
+		{"GetAll", testGetAll},
+		{"GetAllEmptyApp", testGetAllEmptyApp},
+		{"Update", testUpdate},
+		{"UpdateMissing", testUpdateMissing},
+		{"Delete", testDelete},
+		{"DeleteMissing", testDeleteMissing},
+		{"EmptyAppOrKey", testEmptyAppOrKey},
+		{"CompareAndSet", testCompareAndSet},
+		{"CompareAndSetMissing", testCompareAndSetMissing},
+		{"CompareAndSetRace", testCompareAndSetRace},
+		{"CompareAndDelete", testCompareAndDelete},
+		{"Create", testCreate},
+		{"CreateExisting", testCreateExisting},
+		{"TTL", testTTL},
+		{"ConcurrentWriters", testConcurrentWriters},
+		{"LargeApp", testLargeApp},
+		{"Stats", testStats},
+		{"StatsEmptyApp", testStatsEmptyApp},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepository(t)
+			tt.fn(t, repo)
+		})
+	}
+}
+
+func testSetThenGet(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	want := newKeyValue(appID, "greeting", "hello")
+	mustSet(t, repo, want)
+
+	got, err := repo.Get(ctx, appID, "greeting")
+	if err != nil {
+		t.Fatalf("Get: unexpected error: %v", err)
+	}
+	assertKeyValue(t, got, want)
+}
+
+func testSetOverwrites(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	mustSet(t, repo, newKeyValue(appID, "color", "red"))
+	want := newKeyValue(appID, "color", "blue")
+	mustSet(t, repo, want)
+
+	got, err := repo.Get(ctx, appID, "color")
+	if err != nil {
+		t.Fatalf("Get: unexpected error: %v", err)
+	}
+	assertKeyValue(t, got, want)
+
+	all, err := repo.GetAll(ctx, appID)
+	if err != nil {
+		t.Fatalf("GetAll: unexpected error: %v", err)
+	}
+	if len(all) != 1 {
+		t.Fatalf("GetAll: got %d keys after overwrite, want 1", len(all))
+	}
+}
+
+func testGetMissing(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	got, err := repo.Get(ctx, appID, "missing")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("Get: got %+v with error %v, want %v", got, err, repository.ErrNotFound)
+	}
+}
+
+func testGetAll(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+	otherAppID := newAppID(t, repo)
+
+	want := map[string]string{"a": "1", "b": "2", "c": "3"}
+	for key, value := range want {
+		mustSet(t, repo, newKeyValue(appID, key, value))
+	}
+	mustSet(t, repo, newKeyValue(otherAppID, "a", "other"))
+
+	all, err := repo.GetAll(ctx, appID)
+	if err != nil {
+		t.Fatalf("GetAll: unexpected error: %v", err)
+	}
+	assertKeys(t, all, appID, want)
+}
+
+func testGetAllEmptyApp(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	all, err := repo.GetAll(ctx, appID)
+	if err != nil {
+		t.Fatalf("GetAll: unexpected error: %v", err)
+	}
+	if len(all) != 0 {
+		t.Fatalf("GetAll: got %d keys for an empty app, want 0", len(all))
+	}
+}
+
+func testUpdate(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	original := newKeyValue(appID, "flag", "off")
+	mustSet(t, repo, original)
+
+	update := entity.KeyValue{AppID: appID, Key: "flag", Value: "on", Version: original.Version + 1}
+	if err := repo.Update(ctx, update); err != nil {
+		t.Fatalf("Update: unexpected error: %v", err)
+	}
+
+	got, err := repo.Get(ctx, appID, "flag")
+	if err != nil {
+		t.Fatalf("Get: unexpected error: %v", err)
+	}
+	if got.Value != "on" {
+		t.Fatalf("Get after Update: got value %q, want %q", got.Value, "on")
+	}
+	if got.Version != update.Version {
+		t.Fatalf("Get after Update: got version %d, want %d", got.Version, update.Version)
+	}
+}
+
+func testUpdateMissing(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	err := repo.Update(ctx, entity.KeyValue{AppID: appID, Key: "missing", Value: "value"})
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("Update of a missing key: got error %v, want %v", err, repository.ErrNotFound)
+	}
+
+	if got, err := repo.Get(ctx, appID, "missing"); err == nil {
+		t.Fatalf("Update must not create missing keys, but Get returned %+v", got)
+	}
+}
+
+func testDelete(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	mustSet(t, repo, newKeyValue(appID, "keep", "1"))
+	mustSet(t, repo, newKeyValue(appID, "drop", "2"))
+
+	if err := repo.Delete(ctx, appID, "drop"); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+
+	if got, err := repo.Get(ctx, appID, "drop"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("Get after Delete: got %+v with error %v, want %v", got, err, repository.ErrNotFound)
+	}
+
+	all, err := repo.GetAll(ctx, appID)
+	if err != nil {
+		t.Fatalf("GetAll: unexpected error: %v", err)
+	}
+	assertKeys(t, all, appID, map[string]string{"keep": "1"})
+}
+
+func testDeleteMissing(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	if err := repo.Delete(ctx, appID, "missing"); err != nil {
+		t.Fatalf("Delete of a missing key must be a no-op, got error: %v", err)
+	}
+}
+
+func testEmptyAppOrKey(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	cases := []struct {
+		name  string
+		appID string
+		key   string
+	}{
+		{"empty app", "", "key"},
+		{"blank app", "   ", "key"},
+		{"empty key", appID, ""},
+		{"blank key", appID, "   "},
+	}
+
+	for _, c := range cases {
+		kv := newKeyValue(c.appID, c.key, "value")
+		if err := repo.Set(ctx, kv); !errors.Is(err, repository.ErrValidation) {
+			t.Errorf("Set with %s: got error %v, want %v", c.name, err, repository.ErrValidation)
+		}
+		if err := repo.Update(ctx, kv); !errors.Is(err, repository.ErrValidation) {
+			t.Errorf("Update with %s: got error %v, want %v", c.name, err, repository.ErrValidation)
+		}
+		if err := repo.CompareAndSet(ctx, kv, kv.Version); !errors.Is(err, repository.ErrValidation) {
+			t.Errorf("CompareAndSet with %s: got error %v, want %v", c.name, err, repository.ErrValidation)
+		}
+	}
+
+	all, err := repo.GetAll(ctx, appID)
+	if err != nil {
+		t.Fatalf("GetAll: unexpected error: %v", err)
+	}
+	if len(all) != 0 {
+		t.Fatalf("rejected writes must not be stored, got %d keys", len(all))
+	}
+}
+
+func testCompareAndSet(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	original := newKeyValue(appID, "doc", "v1")
+	mustSet(t, repo, original)
+
+	want := newKeyValue(appID, "doc", "v2")
+	want.Version = original.Version + 1
+	if err := repo.CompareAndSet(ctx, want, original.Version); err != nil {
+		t.Fatalf("CompareAndSet with the current version: unexpected error: %v", err)
+	}
+
+	got, err := repo.Get(ctx, appID, "doc")
+	if err != nil {
+		t.Fatalf("Get: unexpected error: %v", err)
+	}
+	assertKeyValue(t, got, want)
+
+	stale := newKeyValue(appID, "doc", "v3")
+	stale.Version = want.Version + 1
+	err = repo.CompareAndSet(ctx, stale, original.Version)
+	if !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Fatalf("CompareAndSet with a stale version: got error %v, want %v", err, repository.ErrVersionMismatch)
+	}
+
+	got, err = repo.Get(ctx, appID, "doc")
+	if err != nil {
+		t.Fatalf("Get: unexpected error: %v", err)
+	}
+	assertKeyValue(t, got, want)
+}
+
+func testCompareAndSetMissing(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	err := repo.CompareAndSet(ctx, newKeyValue(appID, "missing", "value"), 1)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("CompareAndSet of a missing key: got error %v, want %v", err, repository.ErrNotFound)
+	}
+
+	if got, err := repo.Get(ctx, appID, "missing"); err == nil {
+		t.Fatalf("CompareAndSet must not create missing keys, but Get returned %+v", got)
+	}
+}
+
+func testCompareAndSetRace(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	original := newKeyValue(appID, "counter", "0")
+	mustSet(t, repo, original)
+
+	const writers = 8
+	var wg sync.WaitGroup
+	results := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			kv := newKeyValue(appID, "counter", fmt.Sprintf("writer-%d", w))
+			kv.Version = original.Version + int64(w) + 1
+			results <- repo.CompareAndSet(ctx, kv, original.Version)
+		}(w)
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, repository.ErrVersionMismatch):
+			t.Errorf("CompareAndSet: unexpected error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("CompareAndSet: %d writers replaced the same version, want exactly 1", succeeded)
+	}
+}
+
+func testCompareAndDelete(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	original := newKeyValue(appID, "doc", "v1")
+	mustSet(t, repo, original)
+
+	err := repo.CompareAndDelete(ctx, appID, "doc", original.Version+1)
+	if !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Fatalf("CompareAndDelete with a stale version: got error %v, want %v", err, repository.ErrVersionMismatch)
+	}
+	if _, err := repo.Get(ctx, appID, "doc"); err != nil {
+		t.Fatalf("a rejected CompareAndDelete must keep the key, Get returned: %v", err)
+	}
+
+	if err := repo.CompareAndDelete(ctx, appID, "doc", original.Version); err != nil {
+		t.Fatalf("CompareAndDelete with the current version: unexpected error: %v", err)
+	}
+	if got, err := repo.Get(ctx, appID, "doc"); err == nil {
+		t.Fatalf("Get after CompareAndDelete: expected not found error, got %+v", got)
+	}
+
+	err = repo.CompareAndDelete(ctx, appID, "doc", original.Version)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("CompareAndDelete of a missing key: got error %v, want %v", err, repository.ErrNotFound)
+	}
+}
+
+func testCreate(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	want := newKeyValue(appID, "fresh", "value")
+	if err := repo.Create(ctx, want); err != nil {
+		t.Fatalf("Create: unexpected error: %v", err)
+	}
+
+	got, err := repo.Get(ctx, appID, "fresh")
+	if err != nil {
+		t.Fatalf("Get: unexpected error: %v", err)
+	}
+	assertKeyValue(t, got, want)
+}
+
+func testCreateExisting(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	want := newKeyValue(appID, "taken", "first")
+	mustSet(t, repo, want)
+
+	err := repo.Create(ctx, newKeyValue(appID, "taken", "second"))
+	if !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("Create of an existing key: got error %v, want %v", err, repository.ErrConflict)
+	}
+
+	got, err := repo.Get(ctx, appID, "taken")
+	if err != nil {
+		t.Fatalf("Get: unexpected error: %v", err)
+	}
+	assertKeyValue(t, got, want)
+}
+
+func testTTL(t *testing.T, repo repository.KeyValueRepository) {
+	if testing.Short() {
+		t.Skip("waits for values to expire")
+	}
+
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	expiring := newKeyValue(appID, "expiring", "soon")
+	expiring.TTL = 1
+	mustSet(t, repo, expiring)
+	mustSet(t, repo, newKeyValue(appID, "lasting", "forever"))
+
+	got, err := repo.Get(ctx, appID, "expiring")
+	if err != nil {
+		t.Fatalf("Get before expiry: unexpected error: %v", err)
+	}
+	if got.TTL < 0 || got.TTL > 1 {
+		t.Fatalf("Get before expiry: got ttl %d, want at most 1", got.TTL)
+	}
+
+	time.Sleep(2500 * time.Millisecond)
+
+	if got, err := repo.Get(ctx, appID, "expiring"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("Get after expiry: got %+v with error %v, want %v", got, err, repository.ErrNotFound)
+	}
+
+	all, err := repo.GetAll(ctx, appID)
+	if err != nil {
+		t.Fatalf("GetAll: unexpected error: %v", err)
+	}
+	assertKeys(t, all, appID, map[string]string{"lasting": "forever"})
+	if all[0].TTL != 0 {
+		t.Fatalf("a value written without ttl reports ttl %d, want 0", all[0].TTL)
+	}
+}
+
+func testConcurrentWriters(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	const writers = 16
+	const keysPerWriter = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*(keysPerWriter+1))
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keysPerWriter; i++ {
+				key := fmt.Sprintf("writer-%02d/key-%02d", w, i)
+				if err := repo.Set(ctx, newKeyValue(appID, key, key)); err != nil {
+					errs <- fmt.Errorf("Set %s: %w", key, err)
+				}
+			}
+			if err := repo.Set(ctx, newKeyValue(appID, "shared", fmt.Sprintf("writer-%02d", w))); err != nil {
+				errs <- fmt.Errorf("Set shared: %w", err)
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if t.Failed() {
+		return
+	}
+
+	all, err := repo.GetAll(ctx, appID)
+	if err != nil {
+		t.Fatalf("GetAll: unexpected error: %v", err)
+	}
+	if want := writers*keysPerWriter + 1; len(all) != want {
+		t.Fatalf("GetAll: got %d keys, want %d", len(all), want)
+	}
+
+	for _, kv := range all {
+		if kv.Key == "shared" {
+			var w int
+			if _, err := fmt.Sscanf(kv.Value, "writer-%02d", &w); err != nil || w < 0 || w >= writers {
+				t.Errorf("shared key holds %q, which no writer wrote", kv.Value)
+			}
+			continue
+		}
+		if kv.Value != kv.Key {
+			t.Errorf("key %q holds %q, want %q", kv.Key, kv.Value, kv.Key)
+		}
+	}
+}
+
+func testLargeApp(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	// Larger than gocql's default page size, so implementations that stop
+	// after the first page are caught.
+	size := 6000
+	if testing.Short() {
+		size = 500
+	}
+
+	want := make(map[string]string, size)
+	for i := 0; i < size; i++ {
+		want[fmt.Sprintf("key-%05d", i)] = fmt.Sprintf("value-%05d", i)
+	}
+
+	keys := make(chan string)
+	errs := make(chan error, size)
+	var wg sync.WaitGroup
+	for w := 0; w < 32; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				if err := repo.Set(ctx, newKeyValue(appID, key, want[key])); err != nil {
+					errs <- fmt.Errorf("Set %s: %w", key, err)
+				}
+			}
+		}()
+	}
+	for key := range want {
+		keys <- key
+	}
+	close(keys)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	all, err := repo.GetAll(ctx, appID)
+	if err != nil {
+		t.Fatalf("GetAll: unexpected error: %v", err)
+	}
+	assertKeys(t, all, appID, want)
+}
+
+func testStats(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+	otherAppID := newAppID(t, repo)
+
+	before := time.Now().Add(-time.Second)
+	mustSet(t, repo, newKeyValue(appID, "small", "a"))
+	mustSet(t, repo, newKeyValue(appID, "large", "abcdefghij"))
+	mustSet(t, repo, newKeyValue(appID, "medium", "abcde"))
+	mustSet(t, repo, newKeyValue(otherAppID, "huge", "abcdefghijklmnopqrstuvwxyz"))
+	if err := repo.Delete(ctx, appID, "medium"); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+
+	stats, err := repo.Stats(ctx, appID)
+	if err != nil {
+		t.Fatalf("Stats: unexpected error: %v", err)
+	}
+	if stats.AppID != appID || stats.Keys != 2 || stats.TotalValueBytes != 11 {
+		t.Fatalf("Stats: got %s with %d keys of %d bytes, want %s with 2 keys of 11 bytes", stats.AppID, stats.Keys, stats.TotalValueBytes, appID)
+	}
+	if stats.LargestValueBytes != 10 || stats.LargestValueKey != "large" {
+		t.Fatalf("Stats: got largest value %q of %d bytes, want %q of 10 bytes", stats.LargestValueKey, stats.LargestValueBytes, "large")
+	}
+	if stats.LastWriteAt == nil || stats.LastWriteAt.Before(before) || stats.LastWriteAt.After(time.Now().Add(time.Second)) {
+		t.Fatalf("Stats: got last write at %v, want a time since %v", stats.LastWriteAt, before)
+	}
+}
+
+func testStatsEmptyApp(t *testing.T, repo repository.KeyValueRepository) {
+	ctx := context.Background()
+	appID := newAppID(t, repo)
+
+	stats, err := repo.Stats(ctx, appID)
+	if err != nil {
+		t.Fatalf("Stats: unexpected error: %v", err)
+	}
+	if stats.Keys != 0 || stats.TotalValueBytes != 0 || stats.LargestValueBytes != 0 || stats.LastWriteAt != nil {
+		t.Fatalf("Stats: got %+v for an empty app, want zero stats", stats)
+	}
+}
+
+// newAppID returns a unique app ID and removes every key written to it once
+// the test finishes.
+func newAppID(t *testing.T, repo repository.KeyValueRepository) string {
+	t.Helper()
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		t.Fatalf("generating app id: %v", err)
+	}
+	appID := "conformance-" + hex.EncodeToString(buf)
+
+	t.Cleanup(func() {
+		ctx := context.Background()
+		all, err := repo.GetAll(ctx, appID)
+		if err != nil {
+			t.Logf("cleanup of %s: %v", appID, err)
+			return
+		}
+		for _, kv := range all {
+			if err := repo.Delete(ctx, appID, kv.Key); err != nil {
+				t.Logf("cleanup of %s/%s: %v", appID, kv.Key, err)
+			}
+		}
+	})
+
+	return appID
+}
+
+func newKeyValue(appID, key, value string) entity.KeyValue {
+	now := time.Now()
+	return entity.KeyValue{
+		AppID:       appID,
+		Key:         key,
+		Value:       value,
+		ContentType: "text/plain",
+		Version:     now.UnixNano(),
+		CreatedAt:   now,
+	}
+}
+
+func mustSet(t *testing.T, repo repository.KeyValueRepository, kv entity.KeyValue) {
+	t.Helper()
+	if err := repo.Set(context.Background(), kv); err != nil {
+		t.Fatalf("Set %s/%s: unexpected error: %v", kv.AppID, kv.Key, err)
+	}
+}
+
+func assertKeyValue(t *testing.T, got, want entity.KeyValue) {
+	t.Helper()
+	if got.AppID != want.AppID || got.Key != want.Key || got.Value != want.Value {
+		t.Fatalf("got %s/%s=%q, want %s/%s=%q", got.AppID, got.Key, got.Value, want.AppID, want.Key, want.Value)
+	}
+	if got.ContentType != want.ContentType {
+		t.Fatalf("got content type %q, want %q", got.ContentType, want.ContentType)
+	}
+	if got.Version != want.Version {
+		t.Fatalf("got version %d, want %d", got.Version, want.Version)
+	}
+	// Cassandra stores timestamps with millisecond precision.
+	if got.CreatedAt.UnixMilli() != want.CreatedAt.UnixMilli() {
+		t.Fatalf("got created_at %v, want %v", got.CreatedAt, want.CreatedAt)
+	}
+}
+
+func assertKeys(t *testing.T, all []entity.KeyValue, appID string, want map[string]string) {
+	t.Helper()
+
+	got := make(map[string]string, len(all))
+	for _, kv := range all {
+		if kv.AppID != appID {
+			t.Fatalf("GetAll(%s) returned key %q from app %s", appID, kv.Key, kv.AppID)
+		}
+		if _, dup := got[kv.Key]; dup {
+			t.Fatalf("GetAll(%s) returned key %q more than once", appID, kv.Key)
+		}
+		got[kv.Key] = kv.Value
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("GetAll(%s): got %d keys, want %d", appID, len(got), len(want))
+	}
+
+	var mismatched []string
+	for key, value := range want {
+		if got[key] != value {
+			mismatched = append(mismatched, key)
+		}
+	}
+	if len(mismatched) > 0 {
+		sort.Strings(mismatched)
+		t.Fatalf("GetAll(%s): wrong or missing values for keys %v", appID, mismatched)
+	}
+}
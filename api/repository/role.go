@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/gocql/gocql"
+	"github.com/keanutaufan/kvstored/api/db"
+	"github.com/keanutaufan/kvstored/api/entity"
+)
+
+// ErrRoleNotFound is returned when no role has the given name.
+var ErrRoleNotFound = NewError(ErrNotFound, "role not found")
+
+type RoleRepository interface {
+	// Put creates the role or replaces its policies.
+	Put(ctx context.Context, role entity.Role) error
+	Get(ctx context.Context, name string) (entity.Role, error)
+	List(ctx context.Context) ([]entity.Role, error)
+	Delete(ctx context.Context, name string) error
+}
+
+type roleRepository struct {
+	client *db.CassandraClient
+}
+
+func NewRoleRepository(client *db.CassandraClient) RoleRepository {
+	return &roleRepository{client: client}
+}
+
+// Policies are stored as a JSON document, as they are always read and
+// written together with their role.
+func (r *roleRepository) Put(ctx context.Context, role entity.Role) error {
+	policies, err := json.Marshal(role.Policies)
+	if err != nil {
+		return err
+	}
+
+	err = r.client.Session.Query(`
+        INSERT INTO roles (name, policies, updated_at)
+        VALUES (?, ?, ?)
+    `, role.Name, string(policies), role.UpdatedAt).WithContext(ctx).Exec()
+	return translateError(err)
+}
+
+func (r *roleRepository) Get(ctx context.Context, name string) (entity.Role, error) {
+	var role entity.Role
+	var policies string
+	err := r.client.Session.Query(`
+        SELECT name, policies, updated_at FROM roles
+        WHERE name = ?
+    `, name).WithContext(ctx).Scan(&role.Name, &policies, &role.UpdatedAt)
+
+	if errors.Is(err, gocql.ErrNotFound) {
+		return entity.Role{}, ErrRoleNotFound
+	} else if err != nil {
+		return entity.Role{}, translateError(err)
+	}
+	if err := json.Unmarshal([]byte(policies), &role.Policies); err != nil {
+		return entity.Role{}, err
+	}
+	return role, nil
+}
+
+func (r *roleRepository) List(ctx context.Context) ([]entity.Role, error) {
+	var roles []entity.Role
+	iter := r.client.Session.Query(`
+        SELECT name, policies, updated_at FROM roles
+    `).WithContext(ctx).Iter()
+
+	for {
+		var role entity.Role
+		var policies string
+		if !iter.Scan(&role.Name, &policies, &role.UpdatedAt) {
+			break
+		}
+		if err := json.Unmarshal([]byte(policies), &role.Policies); err != nil {
+			iter.Close()
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, translateError(err)
+	}
+	return roles, nil
+}
+
+func (r *roleRepository) Delete(ctx context.Context, name string) error {
+	applied, err := r.client.Session.Query(`
+        DELETE FROM roles
+        WHERE name = ?
+        IF EXISTS
+    `, name).WithContext(ctx).ScanCAS()
+	if err != nil {
+		return translateError(err)
+	}
+	if !applied {
+		return ErrRoleNotFound
+	}
+	return nil
+}
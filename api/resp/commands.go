@@ -0,0 +1,488 @@
+package resp
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/ratelimit"
+	"github.com/keanutaufan/kvstored/api/realtime"
+	"github.com/keanutaufan/kvstored/api/service"
+)
+
+const (
+	defaultScanCount = 10
+	maxScanCount     = 1000 // larger COUNTs are clamped to this
+	maxPatternLength = 1024
+)
+
+func (s *session) ping(args []string) {
+	message := "PONG"
+	if len(args) > 1 {
+		message = args[1]
+	}
+	if len(s.subs) > 0 {
+		s.out.bulks("pong", message)
+		return
+	}
+	if len(args) > 1 {
+		s.out.bulk(message)
+		return
+	}
+	s.out.simple(message)
+}
+
+func (s *session) echo(args []string) {
+	s.out.bulk(args[1])
+}
+
+func (s *session) selectApp(args []string) {
+	s.appID = args[1]
+	s.out.simple("OK")
+}
+
+// authenticate implements AUTH <credential> and AUTH <app_id> <credential>,
+// so clients that only let users configure a username and password can
+// pick an app too. A failed AUTH keeps the connection's current principal.
+func (s *session) authenticate(args []string) {
+	credential := args[len(args)-1]
+	principal, err := s.server.authenticator.Authenticate(s.ctx, credential)
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		s.out.error("WRONGPASS invalid credential")
+		return
+	} else if err != nil {
+		s.writeError(err)
+		return
+	}
+
+	s.principal = principal
+	if len(args) > 2 {
+		s.appID = args[1]
+	}
+	s.out.simple("OK")
+}
+
+// hello rejects RESP3 so clients fall back to RESP2.
+func (s *session) hello(args []string) {
+	s.out.error("NOPROTO unsupported protocol version")
+}
+
+func (s *session) client(args []string) {
+	s.out.simple("OK")
+}
+
+func (s *session) command(args []string) {
+	s.out.array(0)
+}
+
+func (s *session) get(args []string) {
+	if !s.authorize(auth.ActionRead, ratelimit.Read, args[1]) {
+		return
+	}
+	keyValue, err := s.server.keyValueService.Get(s.ctx, s.appID, args[1])
+	if errors.Is(err, service.ErrNotFound) {
+		s.out.null()
+		return
+	} else if err != nil {
+		s.writeError(err)
+		return
+	}
+	s.out.bulk(keyValue.Value)
+}
+
+// set implements SET key value [EX seconds | PX milliseconds] [NX | XX].
+func (s *session) set(args []string) {
+	if !utf8.ValidString(args[2]) {
+		s.out.error("ERR value must be valid UTF-8")
+		return
+	}
+
+	now := time.Now()
+	keyValue := entity.KeyValue{
+		AppID:     s.appID,
+		Key:       args[1],
+		Value:     args[2],
+		Version:   now.UnixNano(),
+		CreatedAt: now,
+	}
+
+	var nx, xx bool
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); option {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if i+1 >= len(args) || keyValue.TTL != 0 {
+				s.out.error("ERR syntax error")
+				return
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n <= 0 {
+				s.out.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			if option == "PX" {
+				// Cassandra TTLs have a granularity of one second.
+				n = (n + 999) / 1000
+			}
+			keyValue.TTL = n
+		default:
+			s.out.error("ERR syntax error")
+			return
+		}
+	}
+	if nx && xx {
+		s.out.error("ERR syntax error")
+		return
+	}
+	if !s.authorize(auth.ActionWrite, ratelimit.Write, keyValue.Key) {
+		return
+	}
+	previous, err := service.Previous(s.ctx, s.server.keyValueService, s.appID, keyValue.Key)
+	if err != nil {
+		s.writeError(err)
+		return
+	}
+
+	changeType := "set"
+	switch {
+	case nx:
+		err = s.server.keyValueService.Create(s.ctx, keyValue)
+	case xx:
+		changeType = "update"
+		err = s.server.keyValueService.Update(s.ctx, keyValue)
+	default:
+		err = s.server.keyValueService.Set(s.ctx, keyValue)
+	}
+	if (nx && errors.Is(err, service.ErrKeyExists)) || (xx && errors.Is(err, service.ErrNotFound)) {
+		s.out.null()
+		return
+	} else if err != nil {
+		s.writeError(err)
+		return
+	}
+
+	s.server.kafkaService.AsyncPublishKeyChange(s.ctx, changeType, keyValue.AppID, keyValue.Key, &keyValue)
+	s.audit(changeType, keyValue.Key, service.PreviousHash(previous), service.HashValue(keyValue.Value))
+	s.out.simple("OK")
+}
+
+func (s *session) del(args []string) {
+	if !s.authorize(auth.ActionDelete, ratelimit.Write, args[1:]...) {
+		return
+	}
+
+	var deleted int64
+	for _, key := range args[1:] {
+		current, err := s.server.keyValueService.Get(s.ctx, s.appID, key)
+		if errors.Is(err, service.ErrNotFound) {
+			continue
+		} else if err != nil {
+			s.writeError(err)
+			return
+		}
+
+		if err := s.server.keyValueService.Delete(s.ctx, s.appID, key); err != nil {
+			s.writeError(err)
+			return
+		}
+		s.server.kafkaService.AsyncPublishKeyChange(s.ctx, "delete", s.appID, key, nil)
+		s.audit("delete", key, service.HashValue(current.Value), "")
+		deleted++
+	}
+	s.out.integer(deleted)
+}
+
+func (s *session) exists(args []string) {
+	if !s.authorize(auth.ActionRead, ratelimit.Read, args[1:]...) {
+		return
+	}
+
+	var found int64
+	for _, key := range args[1:] {
+		_, err := s.server.keyValueService.Get(s.ctx, s.appID, key)
+		if errors.Is(err, service.ErrNotFound) {
+			continue
+		} else if err != nil {
+			s.writeError(err)
+			return
+		}
+		found++
+	}
+	s.out.integer(found)
+}
+
+func (s *session) mget(args []string) {
+	if !s.authorize(auth.ActionRead, ratelimit.Read, args[1:]...) {
+		return
+	}
+
+	values := make([]*string, 0, len(args)-1)
+	for _, key := range args[1:] {
+		keyValue, err := s.server.keyValueService.Get(s.ctx, s.appID, key)
+		if errors.Is(err, service.ErrNotFound) {
+			values = append(values, nil)
+			continue
+		} else if err != nil {
+			s.writeError(err)
+			return
+		}
+		values = append(values, &keyValue.Value)
+	}
+
+	s.out.array(len(values))
+	for _, value := range values {
+		if value == nil {
+			s.out.null()
+		} else {
+			s.out.bulk(*value)
+		}
+	}
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT count]. The cursor is
+// the position in the app's keys sorted by name, so iteration is stable as
+// long as keys are not added or removed concurrently. Keys the caller may not
+// read are left out, and COUNT is clamped to maxScanCount.
+func (s *session) scan(args []string) {
+	cursor, err := strconv.Atoi(args[1])
+	if err != nil || cursor < 0 {
+		s.out.error("ERR invalid cursor")
+		return
+	}
+
+	pattern, count := "*", defaultScanCount
+	for i := 2; i < len(args); i++ {
+		if i+1 >= len(args) {
+			s.out.error("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+			if len(pattern) > maxPatternLength {
+				s.out.error("ERR pattern is too long")
+				return
+			}
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count <= 0 {
+				s.out.error("ERR value is not an integer or out of range")
+				return
+			}
+			count = min(count, maxScanCount)
+		default:
+			s.out.error("ERR syntax error")
+			return
+		}
+		i++
+	}
+
+	if !s.authorize(auth.ActionRead, ratelimit.Read) {
+		return
+	}
+	keyValues, err := s.server.keyValueService.GetAll(s.ctx, s.appID)
+	if err != nil {
+		s.writeError(err)
+		return
+	}
+
+	keys := make([]string, 0, len(keyValues))
+	for _, keyValue := range keyValues {
+		if s.principal.Allows(s.appID, keyValue.Key, auth.ActionRead) {
+			keys = append(keys, keyValue.Key)
+		}
+	}
+	sort.Strings(keys)
+
+	next := cursor + count
+	if next >= len(keys) {
+		next = 0
+	}
+	var page []string
+	if cursor < len(keys) {
+		page = keys[cursor:min(cursor+count, len(keys))]
+	}
+
+	var matched []string
+	for _, key := range page {
+		if matchGlob(pattern, key) {
+			matched = append(matched, key)
+		}
+	}
+
+	s.out.array(2)
+	s.out.bulk(strconv.Itoa(next))
+	s.out.bulks(matched...)
+}
+
+// subscribe treats every channel as a key of the selected app and pushes its
+// changes as JSON encoded realtime.KeyChangeMessage payloads.
+func (s *session) subscribe(args []string) {
+	if !s.authorize(auth.ActionSubscribe, ratelimit.Subscribe, args[1:]...) {
+		return
+	}
+
+	for _, channel := range args[1:] {
+		if _, ok := s.subs[channel]; !ok {
+			sub := s.server.broker.Subscribe(s.appID, channel)
+			s.subs[channel] = sub
+			go s.forward(channel, sub)
+		}
+		s.out.array(3)
+		s.out.bulk("subscribe")
+		s.out.bulk(channel)
+		s.out.integer(int64(len(s.subs)))
+	}
+}
+
+func (s *session) unsubscribe(args []string) {
+	channels := args[1:]
+	if len(channels) == 0 {
+		for channel := range s.subs {
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
+	}
+	if len(channels) == 0 {
+		s.out.array(3)
+		s.out.bulk("unsubscribe")
+		s.out.null()
+		s.out.integer(0)
+		return
+	}
+
+	for _, channel := range channels {
+		if sub, ok := s.subs[channel]; ok {
+			s.server.broker.Unsubscribe(sub)
+			delete(s.subs, channel)
+		}
+		s.out.array(3)
+		s.out.bulk("unsubscribe")
+		s.out.bulk(channel)
+		s.out.integer(int64(len(s.subs)))
+	}
+}
+
+func (s *session) unsubscribeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for channel, sub := range s.subs {
+		s.server.broker.Unsubscribe(sub)
+		delete(s.subs, channel)
+	}
+}
+
+// forward writes the changes received on sub as pub/sub messages until the
+// subscription is closed. Like Redis does with pub/sub clients that cannot
+// keep up, it disconnects the client when the broker ended the subscription
+// because it fell behind.
+func (s *session) forward(channel string, sub *realtime.Subscription) {
+	for keyChange := range sub.C {
+		payload, err := json.Marshal(keyChange)
+		if err != nil {
+			slog.Error("Failed to marshal key change for RESP subscriber", "error", err)
+			continue
+		}
+
+		s.mu.Lock()
+		s.out.bulks("message", channel, string(payload))
+		s.out.w.Flush()
+		s.mu.Unlock()
+	}
+	if err := sub.Err(); err != nil {
+		slog.Warn("Disconnecting a slow RESP subscriber", "remote_addr", s.remoteAddr, "app_id", sub.AppID, "channel", channel)
+		s.conn.Close()
+	}
+}
+
+// matchGlob reports whether key matches a Redis glob pattern supporting
+// *, ?, [...] character classes and backslash escapes. Everything but * matches
+// exactly one character, so on a mismatch it is enough to let the last * seen
+// absorb one more character and retry from there, which keeps matching linear
+// in the length of the pattern times the length of the key.
+func matchGlob(pattern, key string) bool {
+	p, k := 0, 0
+	star, starKey := -1, 0
+	for k < len(key) {
+		if p < len(pattern) && pattern[p] == '*' {
+			star, starKey = p, k
+			p++
+			continue
+		}
+		if p < len(pattern) {
+			if patternSize, keySize, ok := matchOne(pattern[p:], key[k:]); ok {
+				p += patternSize
+				k += keySize
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		_, size := utf8.DecodeRuneInString(key[starKey:])
+		starKey += size
+		p, k = star+1, starKey
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchOne matches the first element of pattern other than * against the
+// start of key, returning how many bytes of each it consumed.
+func matchOne(pattern, key string) (patternSize, keySize int, ok bool) {
+	switch pattern[0] {
+	case '?':
+		_, size := utf8.DecodeRuneInString(key)
+		return 1, size, true
+	case '[':
+		r, size := utf8.DecodeRuneInString(key)
+		end := strings.IndexByte(pattern[1:], ']')
+		if end < 0 {
+			return 0, 0, false
+		}
+		class := pattern[1 : end+1]
+		negate := strings.HasPrefix(class, "^")
+		if negate {
+			class = class[1:]
+		}
+		if matchClass(class, r) == negate {
+			return 0, 0, false
+		}
+		return end + 2, size, true
+	case '\\':
+		if len(pattern) > 1 {
+			return 2, 1, key[0] == pattern[1]
+		}
+	}
+	return 1, 1, key[0] == pattern[0]
+}
+
+func matchClass(class string, r rune) bool {
+	runes := []rune(class)
+	for i := 0; i < len(runes); i++ {
+		if i+2 < len(runes) && runes[i+1] == '-' {
+			if runes[i] <= r && r <= runes[i+2] {
+				return true
+			}
+			i += 2
+			continue
+		}
+		if runes[i] == r {
+			return true
+		}
+	}
+	return false
+}
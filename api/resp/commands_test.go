@@ -0,0 +1,51 @@
+package resp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "users:1", false},
+		{"*:1", "user:1", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "héllo", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b", "ha", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"**a**", "bab", true},
+		{"*a", "", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestMatchGlobManyStars(t *testing.T) {
+	pattern := strings.Repeat("*a", 50) + "*b"
+	key := strings.Repeat("a", 1000)
+
+	start := time.Now()
+	if matchGlob(pattern, key) {
+		t.Fatalf("matchGlob(%q, %q) = true, want false", pattern, key)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("matching took %v", elapsed)
+	}
+}
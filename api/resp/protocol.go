@@ -0,0 +1,128 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Limits on what one command may make the server buffer, so a malformed or
+// hostile client cannot make it allocate arbitrary amounts of memory. Bulk
+// strings are capped well above the default value size limit, and lines,
+// which only carry lengths and inline commands, at Redis' inline limit.
+const (
+	maxBulkLength      = 16 * 1024 * 1024
+	maxMultibulkLength = 64 * 1024
+	maxCommandLength   = 64 * 1024 * 1024
+	maxLineLength      = 64 * 1024
+)
+
+var errProtocol = errors.New("protocol error")
+
+// readCommand reads one command, either as a RESP array of bulk strings or as
+// an inline command such as those typed into telnet.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count < 0 || count > maxMultibulkLength {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+
+	args := make([]string, 0, count)
+	total := 0
+	for i := 0; i < count; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%s'", errProtocol, line)
+		}
+
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 || length > maxBulkLength {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+		if total += length; total > maxCommandLength {
+			return nil, fmt.Errorf("%w: command too long", errProtocol)
+		}
+
+		buf := make([]byte, length+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[length] != '\r' || buf[length+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", errProtocol)
+		}
+		args = append(args, string(buf[:length]))
+	}
+	return args, nil
+}
+
+// readLine reads a line of at most maxLineLength bytes.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLineLength {
+			return "", fmt.Errorf("%w: too big inline request", errProtocol)
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return "", err
+		}
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// writer encodes RESP2 replies.
+type writer struct {
+	w *bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	w.w.WriteString("+" + s + "\r\n")
+}
+
+func (w writer) error(s string) {
+	w.w.WriteString("-" + s + "\r\n")
+}
+
+func (w writer) integer(n int64) {
+	w.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w writer) bulk(s string) {
+	w.w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (w writer) null() {
+	w.w.WriteString("$-1\r\n")
+}
+
+func (w writer) array(n int) {
+	w.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+func (w writer) bulks(values ...string) {
+	w.array(len(values))
+	for _, value := range values {
+		w.bulk(value)
+	}
+}
@@ -0,0 +1,303 @@
+// Package resp serves the key-value store over the Redis RESP2 protocol so
+// existing Redis clients can talk to kvstored unchanged. A connection
+// authenticates with AUTH <credential> and picks its app with SELECT
+// <app_id>, or does both with AUTH <app_id> <credential>, before issuing
+// commands. Commands are authorized, rate limited and audited like their
+// HTTP counterparts.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/ratelimit"
+	"github.com/keanutaufan/kvstored/api/realtime"
+	"github.com/keanutaufan/kvstored/api/service"
+)
+
+type Server struct {
+	keyValueService service.KeyValueService
+	kafkaService    *realtime.KafkaService
+	broker          *realtime.Broker
+	authenticator   auth.Authenticator
+	limiter         *ratelimit.Limiter
+	auditService    service.AuditService
+
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	handlers sync.WaitGroup
+	mu       sync.Mutex
+}
+
+func NewServer(keyValueService service.KeyValueService, kafkaService *realtime.KafkaService, broker *realtime.Broker, authenticator auth.Authenticator, limiter *ratelimit.Limiter, auditService service.AuditService) *Server {
+	return &Server{
+		keyValueService: keyValueService,
+		kafkaService:    kafkaService,
+		broker:          broker,
+		authenticator:   authenticator,
+		limiter:         limiter,
+		auditService:    auditService,
+		conns:           make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections on listener until Close is called.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return listener.Close()
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.handlers.Add(1)
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+// Close stops accepting connections, closes the open ones and waits for
+// their commands to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.handlers.Wait()
+	return err
+}
+
+func (s *Server) handle(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sess := &session{
+		server:     s,
+		ctx:        ctx,
+		conn:       conn,
+		reader:     bufio.NewReader(conn),
+		out:        writer{w: bufio.NewWriter(conn)},
+		remoteAddr: conn.RemoteAddr().String(),
+		subs:       make(map[string]*realtime.Subscription),
+	}
+	// Without authentication every connection is let in without AUTH.
+	sess.principal, _ = s.authenticator.Authenticate(ctx, "")
+
+	defer func() {
+		defer s.handlers.Done()
+		cancel()
+		sess.unsubscribeAll()
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	for {
+		args, err := readCommand(sess.reader)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				sess.mu.Lock()
+				sess.out.error("ERR " + err.Error())
+				sess.out.w.Flush()
+				sess.mu.Unlock()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Warn("RESP connection failed", "remote_addr", conn.RemoteAddr().String(), "error", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		sess.mu.Lock()
+		quit := sess.dispatch(args)
+		if sess.reader.Buffered() == 0 || quit {
+			err = sess.out.w.Flush()
+		}
+		sess.mu.Unlock()
+
+		if quit || err != nil {
+			return
+		}
+	}
+}
+
+// session is the state of one client connection. mu guards out, which the
+// subscription goroutines write to as well.
+type session struct {
+	server *Server
+	ctx    context.Context
+	conn   net.Conn
+	reader *bufio.Reader
+	out    writer
+	mu     sync.Mutex
+
+	remoteAddr string
+	principal  *auth.Principal // nil until the connection authenticates
+	appID      string
+	subs       map[string]*realtime.Subscription // channel (key) -> subscription
+}
+
+type command struct {
+	handler func(s *session, args []string)
+	arity   int // minimum number of arguments including the command name
+	app     bool
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":        {handler: (*session).ping, arity: 1},
+		"ECHO":        {handler: (*session).echo, arity: 2},
+		"SELECT":      {handler: (*session).selectApp, arity: 2},
+		"AUTH":        {handler: (*session).authenticate, arity: 2},
+		"HELLO":       {handler: (*session).hello, arity: 1},
+		"CLIENT":      {handler: (*session).client, arity: 2},
+		"COMMAND":     {handler: (*session).command, arity: 1},
+		"GET":         {handler: (*session).get, arity: 2, app: true},
+		"SET":         {handler: (*session).set, arity: 3, app: true},
+		"DEL":         {handler: (*session).del, arity: 2, app: true},
+		"EXISTS":      {handler: (*session).exists, arity: 2, app: true},
+		"MGET":        {handler: (*session).mget, arity: 2, app: true},
+		"SCAN":        {handler: (*session).scan, arity: 2, app: true},
+		"SUBSCRIBE":   {handler: (*session).subscribe, arity: 2, app: true},
+		"UNSUBSCRIBE": {handler: (*session).unsubscribe, arity: 1},
+	}
+}
+
+// subscribedCommands are the only commands allowed while the connection has
+// active subscriptions, mirroring Redis.
+var subscribedCommands = map[string]bool{
+	"SUBSCRIBE":   true,
+	"UNSUBSCRIBE": true,
+	"PING":        true,
+	"QUIT":        true,
+}
+
+// dispatch runs one command and reports whether the connection should close.
+func (s *session) dispatch(args []string) bool {
+	name := strings.ToUpper(args[0])
+
+	if len(s.subs) > 0 && !subscribedCommands[name] {
+		s.out.error("ERR Can't execute '" + strings.ToLower(name) + "': only (UN)SUBSCRIBE / PING / QUIT are allowed in this context")
+		return false
+	}
+
+	if name == "QUIT" {
+		s.out.simple("OK")
+		return true
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		s.out.error("ERR unknown command '" + args[0] + "'")
+		return false
+	}
+	if len(args) < cmd.arity {
+		s.out.error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return false
+	}
+	if cmd.app && s.principal == nil {
+		s.out.error("NOAUTH Authentication required.")
+		return false
+	}
+	if cmd.app && s.appID == "" {
+		s.out.error("ERR choose an app with SELECT <app_id> or AUTH <app_id> <credential> first")
+		return false
+	}
+
+	cmd.handler(s, args)
+	return false
+}
+
+// authorize checks that the session may perform action on keys, or on at
+// least some keys of its app when there are none, and charges the command
+// to the rate limits of class. It reports the error to the client and
+// returns false when the command may not run.
+func (s *session) authorize(action auth.Action, class ratelimit.Class, keys ...string) bool {
+	err := s.principal.AuthorizeApp(s.appID, action)
+	for _, key := range keys {
+		if err != nil {
+			break
+		}
+		err = s.principal.Authorize(s.appID, key, action)
+	}
+	if err == nil {
+		err = s.server.limiter.Allow(ratelimit.Client(s.principal, s.remoteAddr), s.appID, class)
+	}
+	if err != nil {
+		s.writeError(err)
+		return false
+	}
+	return true
+}
+
+// audit records a successful write. The write has already happened, so a
+// failure to record it is logged rather than returned to the client.
+func (s *session) audit(action, key, oldHash, newHash string) {
+	ip := s.remoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	record := entity.AuditRecord{
+		AppID:     s.appID,
+		Key:       key,
+		Action:    action,
+		Actor:     s.principal.Subject,
+		IP:        ip,
+		UserAgent: "resp",
+		OldHash:   oldHash,
+		NewHash:   newHash,
+	}
+	if _, err := s.server.auditService.Record(s.ctx, record); err != nil {
+		slog.ErrorContext(s.ctx, "Failed to record audit log", "action", action, "app_id", s.appID, "key", key, "error", err)
+	}
+}
+
+// writeError reports a service error, marking outages as retriable.
+func (s *session) writeError(err error) {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		s.out.error("NOAUTH " + err.Error())
+	case errors.Is(err, auth.ErrForbidden):
+		s.out.error("NOPERM " + err.Error())
+	case errors.Is(err, service.ErrUnavailable), errors.Is(err, service.ErrTimeout):
+		s.out.error("TRYAGAIN " + err.Error())
+	default:
+		s.out.error("ERR " + err.Error())
+	}
+}
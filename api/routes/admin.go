@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/controller"
+)
+
+func AdminRoutes(router *gin.Engine, adminController controller.AdminController, handlers ...gin.HandlerFunc) {
+	routes := router.Group("/admin", handlers...)
+	{
+		routes.GET("/apps/:app_id/stats", adminController.AppStats)
+		routes.GET("/subscriptions", adminController.Subscriptions)
+	}
+}
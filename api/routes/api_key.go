@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/controller"
+)
+
+func APIKeyRoutes(router *gin.Engine, apiKeyController controller.APIKeyController, handlers ...gin.HandlerFunc) {
+	routes := router.Group("/api-keys", handlers...)
+	{
+		routes.POST("", apiKeyController.Create)
+		routes.GET("", apiKeyController.List)
+		routes.DELETE("/:id", apiKeyController.Revoke)
+	}
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/controller"
+)
+
+func AuditRoutes(router *gin.Engine, auditController controller.AuditController, handlers ...gin.HandlerFunc) {
+	routes := router.Group("/audit", handlers...)
+	{
+		routes.GET("/:app_id", auditController.List)
+	}
+}
@@ -5,13 +5,16 @@ import (
 	"github.com/keanutaufan/kvstored/api/controller"
 )
 
-func KeyValueRoutes(router *gin.Engine, keyValueController controller.KeyValueController) {
-	routes := router.Group("/kv")
+func KeyValueRoutes(router *gin.Engine, keyValueController controller.KeyValueController, handlers ...gin.HandlerFunc) {
+	routes := router.Group("/kv", handlers...)
 	{
 		routes.GET("/:app_id", keyValueController.GetAll)
 		routes.GET("/:app_id/:key", keyValueController.Get)
 		routes.POST("/", keyValueController.Set)
 		routes.PUT("/", keyValueController.Update)
 		routes.DELETE("/:app_id/:key", keyValueController.Delete)
+		routes.POST("/:app_id/:key", keyValueController.Set)
+		routes.PUT("/:app_id/:key", keyValueController.Set)
+		routes.PATCH("/:app_id/:key", keyValueController.Update)
 	}
 }
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/controller"
+)
+
+func LogLevelRoutes(router *gin.Engine, logLevelController controller.LogLevelController, handlers ...gin.HandlerFunc) {
+	routes := router.Group("/admin/log-level", handlers...)
+	{
+		routes.GET("", logLevelController.Get)
+		routes.PUT("", logLevelController.Put)
+	}
+}
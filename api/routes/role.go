@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/controller"
+)
+
+func RoleRoutes(router *gin.Engine, roleController controller.RoleController, handlers ...gin.HandlerFunc) {
+	routes := router.Group("/roles", handlers...)
+	{
+		routes.GET("", roleController.List)
+		routes.GET("/:name", roleController.Get)
+		routes.PUT("/:name", roleController.Put)
+		routes.DELETE("/:name", roleController.Delete)
+	}
+}
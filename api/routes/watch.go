@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/keanutaufan/kvstored/api/controller"
+)
+
+func WatchRoutes(router *gin.Engine, watchController controller.WatchController, handlers ...gin.HandlerFunc) {
+	routes := router.Group("/watch", handlers...)
+	{
+		routes.GET("/:app_id", watchController.Watch)
+		routes.GET("/:app_id/:key", watchController.Watch)
+	}
+}
@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// requestIDMetadata carries the client's ID for a call, like the
+// X-Request-ID header of HTTP requests.
+const requestIDMetadata = "x-request-id"
+
+// UnaryInterceptor authenticates every call with the credential in its
+// authorization ("Bearer <credential>") or x-api-key metadata, and stores
+// the principal and a request ID in the call's context.
+func UnaryInterceptor(authenticator auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authenticate(ctx, authenticator)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor authenticates streaming calls like UnaryInterceptor.
+func StreamInterceptor(authenticator auth.Authenticator) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(stream.Context(), authenticator)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+func authenticate(ctx context.Context, authenticator auth.Authenticator) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := make(http.Header, len(md))
+	for key, values := range md {
+		header[http.CanonicalHeaderKey(key)] = values
+	}
+
+	id := header.Get(requestIDMetadata)
+	if id == "" {
+		id = logging.NewRequestID()
+	}
+	ctx = logging.WithRequestID(ctx, id)
+
+	principal, err := authenticator.Authenticate(ctx, auth.Credential(header))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return auth.NewContext(ctx, principal), nil
+}
+
+// authenticatedStream replaces the context of a stream with one carrying
+// its principal.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
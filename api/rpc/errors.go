@@ -0,0 +1,37 @@
+package rpc
+
+import (
+	"errors"
+
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/ratelimit"
+	"github.com/keanutaufan/kvstored/api/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusCodes maps service error kinds to gRPC status codes. The first
+// matching entry wins, so more specific errors come first.
+var statusCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{auth.ErrUnauthenticated, codes.Unauthenticated},
+	{auth.ErrForbidden, codes.PermissionDenied},
+	{ratelimit.ErrRateLimited, codes.ResourceExhausted},
+	{service.ErrVersionMismatch, codes.FailedPrecondition},
+	{service.ErrValidation, codes.InvalidArgument},
+	{service.ErrNotFound, codes.NotFound},
+	{service.ErrConflict, codes.Aborted},
+	{service.ErrTimeout, codes.DeadlineExceeded},
+	{service.ErrUnavailable, codes.Unavailable},
+}
+
+func toStatus(err error) error {
+	for _, statusCode := range statusCodes {
+		if errors.Is(err, statusCode.err) {
+			return status.Error(statusCode.code, err.Error())
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,294 @@
+package rpc
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/kvstorepb"
+	"github.com/keanutaufan/kvstored/api/logging"
+	"github.com/keanutaufan/kvstored/api/ratelimit"
+	"github.com/keanutaufan/kvstored/api/realtime"
+	"github.com/keanutaufan/kvstored/api/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type keyValueServer struct {
+	kvstorepb.UnimplementedKeyValueServiceServer
+
+	keyValueService service.KeyValueService
+	kafkaService    *realtime.KafkaService
+	broker          *realtime.Broker
+	limiter         *ratelimit.Limiter
+	auditService    service.AuditService
+}
+
+// NewKeyValueServer returns the gRPC key-value service. Calls must carry the
+// principal stored by UnaryInterceptor or StreamInterceptor, and are
+// authorized, rate limited and audited like their HTTP counterparts.
+func NewKeyValueServer(keyValueService service.KeyValueService, kafkaService *realtime.KafkaService, broker *realtime.Broker, limiter *ratelimit.Limiter, auditService service.AuditService) *keyValueServer {
+	return &keyValueServer{
+		keyValueService: keyValueService,
+		kafkaService:    kafkaService,
+		broker:          broker,
+		limiter:         limiter,
+		auditService:    auditService,
+	}
+}
+
+// authorize checks that the caller may perform action on key in appID, or
+// on at least some keys of appID when key is empty, and charges the call to
+// the rate limits of class.
+func (s *keyValueServer) authorize(ctx context.Context, appID, key string, action auth.Action, class ratelimit.Class) error {
+	principal := auth.FromContext(ctx)
+	var err error
+	if key != "" {
+		err = principal.Authorize(appID, key, action)
+	} else {
+		err = principal.AuthorizeApp(appID, action)
+	}
+	if err == nil {
+		err = s.limiter.Allow(ratelimit.Client(principal, remoteAddr(ctx)), appID, class)
+	}
+	if err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// audit records a successful write. The write has already happened, so a
+// failure to record it is logged rather than returned to the client.
+func (s *keyValueServer) audit(ctx context.Context, action, appID, key, oldHash, newHash string) {
+	var userAgent string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		userAgent = strings.Join(md.Get("user-agent"), " ")
+	}
+	ip := remoteAddr(ctx)
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	record := entity.AuditRecord{
+		AppID:     appID,
+		Key:       key,
+		Action:    action,
+		Actor:     auth.FromContext(ctx).Subject,
+		IP:        ip,
+		UserAgent: userAgent,
+		OldHash:   oldHash,
+		NewHash:   newHash,
+		RequestID: logging.RequestID(ctx),
+	}
+	if _, err := s.auditService.Record(ctx, record); err != nil {
+		slog.ErrorContext(ctx, "Failed to record audit log", "action", action, "app_id", appID, "key", key, "error", err)
+	}
+}
+
+func remoteAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+func (s *keyValueServer) Get(ctx context.Context, req *kvstorepb.GetRequest) (*kvstorepb.KeyValue, error) {
+	if err := s.authorize(ctx, req.GetAppId(), req.GetKey(), auth.ActionRead, ratelimit.Read); err != nil {
+		return nil, err
+	}
+
+	keyValue, err := s.keyValueService.Get(ctx, req.GetAppId(), req.GetKey())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toProto(keyValue), nil
+}
+
+func (s *keyValueServer) Set(ctx context.Context, req *kvstorepb.SetRequest) (*kvstorepb.WriteResponse, error) {
+	keyValue := entity.KeyValue{
+		AppID:       req.GetAppId(),
+		Key:         req.GetKey(),
+		Value:       req.GetValue(),
+		ContentType: req.GetContentType(),
+		CreatedAt:   time.Now(),
+	}
+	keyValue.Version = keyValue.CreatedAt.UnixNano()
+
+	if err := s.authorize(ctx, keyValue.AppID, keyValue.Key, auth.ActionWrite, ratelimit.Write); err != nil {
+		return nil, err
+	}
+
+	previous, err := service.Previous(ctx, s.keyValueService, keyValue.AppID, keyValue.Key)
+	if err == nil {
+		// Writes carry no TTL, so they keep the expiry of the value they
+		// replace.
+		if previous != nil {
+			keyValue.TTL = previous.TTL
+		}
+		if req.ExpectedVersion != nil {
+			err = s.keyValueService.CompareAndSet(ctx, keyValue, req.GetExpectedVersion())
+		} else {
+			err = s.keyValueService.Set(ctx, keyValue)
+		}
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	s.audit(ctx, "set", keyValue.AppID, keyValue.Key, service.PreviousHash(previous), service.HashValue(keyValue.Value))
+	s.kafkaService.AsyncPublishKeyChange(ctx, "set", keyValue.AppID, keyValue.Key, &keyValue)
+
+	return &kvstorepb.WriteResponse{Version: keyValue.Version}, nil
+}
+
+func (s *keyValueServer) Update(ctx context.Context, req *kvstorepb.UpdateRequest) (*kvstorepb.WriteResponse, error) {
+	keyValue := entity.KeyValue{
+		AppID:       req.GetAppId(),
+		Key:         req.GetKey(),
+		Value:       req.GetValue(),
+		ContentType: req.GetContentType(),
+		Version:     time.Now().UnixNano(),
+	}
+
+	if err := s.authorize(ctx, keyValue.AppID, keyValue.Key, auth.ActionWrite, ratelimit.Write); err != nil {
+		return nil, err
+	}
+
+	var oldHash string
+	current, err := s.keyValueService.Get(ctx, keyValue.AppID, keyValue.Key)
+	if err == nil {
+		oldHash = service.HashValue(current.Value)
+		keyValue.TTL = current.TTL
+		if req.ExpectedVersion != nil {
+			keyValue.CreatedAt = current.CreatedAt
+			err = s.keyValueService.CompareAndSet(ctx, keyValue, req.GetExpectedVersion())
+		} else {
+			err = s.keyValueService.Update(ctx, keyValue)
+		}
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	s.audit(ctx, "update", keyValue.AppID, keyValue.Key, oldHash, service.HashValue(keyValue.Value))
+	s.kafkaService.AsyncPublishKeyChange(ctx, "update", keyValue.AppID, keyValue.Key, &keyValue)
+
+	return &kvstorepb.WriteResponse{Version: keyValue.Version}, nil
+}
+
+func (s *keyValueServer) Delete(ctx context.Context, req *kvstorepb.DeleteRequest) (*kvstorepb.DeleteResponse, error) {
+	if err := s.authorize(ctx, req.GetAppId(), req.GetKey(), auth.ActionDelete, ratelimit.Write); err != nil {
+		return nil, err
+	}
+
+	previous, err := service.Previous(ctx, s.keyValueService, req.GetAppId(), req.GetKey())
+	if err == nil {
+		if req.ExpectedVersion != nil {
+			err = s.keyValueService.CompareAndDelete(ctx, req.GetAppId(), req.GetKey(), req.GetExpectedVersion())
+		} else {
+			err = s.keyValueService.Delete(ctx, req.GetAppId(), req.GetKey())
+		}
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	s.audit(ctx, "delete", req.GetAppId(), req.GetKey(), service.PreviousHash(previous), "")
+	s.kafkaService.AsyncPublishKeyChange(ctx, "delete", req.GetAppId(), req.GetKey(), nil)
+
+	return &kvstorepb.DeleteResponse{}, nil
+}
+
+func (s *keyValueServer) List(ctx context.Context, req *kvstorepb.ListRequest) (*kvstorepb.ListResponse, error) {
+	if err := s.authorize(ctx, req.GetAppId(), "", auth.ActionRead, ratelimit.Read); err != nil {
+		return nil, err
+	}
+
+	keyValues, err := s.keyValueService.GetAll(ctx, req.GetAppId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	// Callers whose policies cover only some keys see only those keys.
+	principal := auth.FromContext(ctx)
+	resp := &kvstorepb.ListResponse{KeyValues: make([]*kvstorepb.KeyValue, 0, len(keyValues))}
+	for _, keyValue := range keyValues {
+		if !principal.Allows(keyValue.AppID, keyValue.Key, auth.ActionRead) {
+			continue
+		}
+		resp.KeyValues = append(resp.KeyValues, toProto(keyValue))
+	}
+	return resp, nil
+}
+
+func (s *keyValueServer) Watch(req *kvstorepb.WatchRequest, stream grpc.ServerStreamingServer[kvstorepb.WatchEvent]) error {
+	if req.GetAppId() == "" {
+		return status.Error(codes.InvalidArgument, "app_id cannot be empty")
+	}
+	ctx := stream.Context()
+	if err := s.authorize(ctx, req.GetAppId(), req.GetKey(), auth.ActionSubscribe, ratelimit.Subscribe); err != nil {
+		return err
+	}
+	principal := auth.FromContext(ctx)
+
+	sub := s.broker.Subscribe(req.GetAppId(), req.GetKey())
+	defer s.broker.Unsubscribe(sub)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case keyChange, ok := <-sub.C:
+			if !ok {
+				if err := sub.Err(); err != nil {
+					return status.Error(codes.DataLoss, err.Error())
+				}
+				return nil
+			}
+			// App watchers only see the keys they may subscribe to.
+			if !principal.Allows(keyChange.AppID, keyChange.Key, auth.ActionSubscribe) {
+				continue
+			}
+			if err := stream.Send(toWatchEvent(keyChange)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func toProto(keyValue entity.KeyValue) *kvstorepb.KeyValue {
+	return &kvstorepb.KeyValue{
+		AppId:       keyValue.AppID,
+		Key:         keyValue.Key,
+		Value:       keyValue.Value,
+		ContentType: keyValue.ContentType,
+		Version:     keyValue.Version,
+		CreatedAt:   timestamppb.New(keyValue.CreatedAt),
+	}
+}
+
+var watchEventTypes = map[string]kvstorepb.WatchEvent_Type{
+	"set":    kvstorepb.WatchEvent_TYPE_SET,
+	"update": kvstorepb.WatchEvent_TYPE_UPDATED,
+	"delete": kvstorepb.WatchEvent_TYPE_DELETED,
+}
+
+func toWatchEvent(keyChange realtime.KeyChangeMessage) *kvstorepb.WatchEvent {
+	event := &kvstorepb.WatchEvent{
+		Type:  watchEventTypes[keyChange.Type],
+		AppId: keyChange.AppID,
+		Key:   keyChange.Key,
+	}
+	if keyChange.Value != nil {
+		event.Value = toProto(*keyChange.Value)
+	}
+	return event
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/repository"
+)
+
+// API keys look like kvs_<id>_<secret>. The ID locates the stored key and
+// only a hash of the secret is stored.
+const apiKeyPrefix = "kvs_"
+
+// apiKeyCacheTTL bounds how long a node keeps trusting a cached key, and so
+// how long a key revoked on another node stays usable here.
+const apiKeyCacheTTL = 30 * time.Second
+
+var (
+	errEmptyAPIKeyName   = repository.NewError(ErrValidation, "name cannot be empty")
+	errEmptyAPIKeyScopes = repository.NewError(ErrValidation, "scopes and roles cannot both be empty")
+	errEmptyScopeAppID   = repository.NewError(ErrValidation, "scope app_id cannot be empty")
+)
+
+type APIKeyService interface {
+	auth.Authenticator
+	// Create mints a key and returns it with the secret key string, which
+	// is not stored and cannot be retrieved later. The key holds the
+	// app-wide permissions in scopes plus the policies of roles.
+	Create(ctx context.Context, name string, scopes map[string]auth.Permission, roles []string) (entity.APIKey, string, error)
+	Get(ctx context.Context, id string) (entity.APIKey, error)
+	List(ctx context.Context) ([]entity.APIKey, error)
+	Revoke(ctx context.Context, id string) error
+}
+
+type apiKeyService struct {
+	apiKeyRepository repository.APIKeyRepository
+	adminKey         string
+
+	cache map[string]cachedAPIKey
+	mu    sync.Mutex
+}
+
+type cachedAPIKey struct {
+	apiKey  entity.APIKey
+	expires time.Time
+}
+
+// NewAPIKeyService returns the API key service. A non-empty adminKey is
+// accepted as a key with admin permission on every app, so the first keys
+// can be minted.
+func NewAPIKeyService(apiKeyRepository repository.APIKeyRepository, adminKey string) *apiKeyService {
+	return &apiKeyService{
+		apiKeyRepository: apiKeyRepository,
+		adminKey:         adminKey,
+		cache:            make(map[string]cachedAPIKey),
+	}
+}
+
+func (s *apiKeyService) Create(ctx context.Context, name string, scopes map[string]auth.Permission, roles []string) (entity.APIKey, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return entity.APIKey{}, "", errEmptyAPIKeyName
+	}
+	if len(scopes) == 0 && len(roles) == 0 {
+		return entity.APIKey{}, "", errEmptyAPIKeyScopes
+	}
+
+	apiKey := entity.APIKey{
+		ID:        randomID(),
+		Name:      name,
+		Scopes:    make(map[string]string, len(scopes)),
+		Roles:     roles,
+		CreatedAt: time.Now(),
+	}
+	for appID, permission := range scopes {
+		if strings.TrimSpace(appID) == "" {
+			return entity.APIKey{}, "", errEmptyScopeAppID
+		}
+		apiKey.Scopes[appID] = permission.String()
+	}
+
+	secret := randomSecret()
+	apiKey.Hash = hashSecret(secret)
+
+	if err := s.apiKeyRepository.Create(ctx, apiKey); err != nil {
+		return entity.APIKey{}, "", err
+	}
+	return apiKey, apiKeyPrefix + apiKey.ID + "_" + secret, nil
+}
+
+func (s *apiKeyService) Get(ctx context.Context, id string) (entity.APIKey, error) {
+	return s.apiKeyRepository.Get(ctx, id)
+}
+
+func (s *apiKeyService) List(ctx context.Context) ([]entity.APIKey, error) {
+	return s.apiKeyRepository.List(ctx)
+}
+
+func (s *apiKeyService) Revoke(ctx context.Context, id string) error {
+	if err := s.apiKeyRepository.Revoke(ctx, id, time.Now()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.cache, id)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *apiKeyService) Authenticate(ctx context.Context, credential string) (*auth.Principal, error) {
+	if credential == "" {
+		return nil, auth.ErrMissingCredential
+	}
+	if s.adminKey != "" && subtle.ConstantTimeCompare([]byte(credential), []byte(s.adminKey)) == 1 {
+		return &auth.Principal{Subject: "admin", Scopes: map[string]auth.Permission{auth.AllApps: auth.Admin}}, nil
+	}
+
+	id, secret, ok := strings.Cut(strings.TrimPrefix(credential, apiKeyPrefix), "_")
+	if !ok || !strings.HasPrefix(credential, apiKeyPrefix) {
+		return nil, auth.ErrInvalidCredential
+	}
+
+	apiKey, err := s.lookup(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, auth.ErrInvalidCredential
+	} else if err != nil {
+		return nil, err
+	}
+
+	if apiKey.RevokedAt != nil || subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(apiKey.Hash)) != 1 {
+		return nil, auth.ErrInvalidCredential
+	}
+
+	principal := &auth.Principal{
+		Subject: "api-key:" + apiKey.ID,
+		Scopes:  make(map[string]auth.Permission, len(apiKey.Scopes)),
+		Roles:   apiKey.Roles,
+	}
+	for appID, name := range apiKey.Scopes {
+		if permission, err := auth.ParsePermission(name); err == nil {
+			principal.Scopes[appID] = permission
+		}
+	}
+	return principal, nil
+}
+
+func (s *apiKeyService) lookup(ctx context.Context, id string) (entity.APIKey, error) {
+	s.mu.Lock()
+	cached, ok := s.cache[id]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.apiKey, nil
+	}
+
+	apiKey, err := s.apiKeyRepository.Get(ctx, id)
+	if err != nil {
+		return entity.APIKey{}, err
+	}
+
+	s.mu.Lock()
+	s.cache[id] = cachedAPIKey{apiKey: apiKey, expires: time.Now().Add(apiKeyCacheTTL)}
+	s.mu.Unlock()
+	return apiKey, nil
+}
+
+// randomID returns a hex ID, which never contains the "_" separating it from
+// the secret.
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func randomSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
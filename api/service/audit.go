@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/repository"
+)
+
+const (
+	// maxAuditRange bounds how many daily partitions one query reads.
+	maxAuditRange     = 31 * 24 * time.Hour
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+var (
+	errAuditRangeOrder = repository.NewError(ErrValidation, "from must not be after to")
+	errAuditRangeSize  = repository.NewError(ErrValidation, "time range cannot exceed 31 days")
+	errAuditLimit      = repository.NewError(ErrValidation, "limit must be between 1 and 1000")
+)
+
+type AuditService interface {
+	Record(ctx context.Context, record entity.AuditRecord) (entity.AuditRecord, error)
+	// Query returns the records of appID between from and to, newest first.
+	// A non-empty key only returns records of that key, and a zero limit
+	// returns up to 100 records.
+	Query(ctx context.Context, appID, key string, from, to time.Time, limit int) ([]entity.AuditRecord, error)
+}
+
+type auditService struct {
+	auditRepository repository.AuditRepository
+}
+
+func NewAuditService(auditRepository repository.AuditRepository) *auditService {
+	return &auditService{auditRepository: auditRepository}
+}
+
+func (s *auditService) Record(ctx context.Context, record entity.AuditRecord) (entity.AuditRecord, error) {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	err := s.auditRepository.Append(ctx, &record)
+	return record, err
+}
+
+func (s *auditService) Query(ctx context.Context, appID, key string, from, to time.Time, limit int) ([]entity.AuditRecord, error) {
+	if from.After(to) {
+		return nil, errAuditRangeOrder
+	}
+	if to.Sub(from) > maxAuditRange {
+		return nil, errAuditRangeSize
+	}
+	if limit == 0 {
+		limit = defaultAuditLimit
+	}
+	if limit < 0 || limit > maxAuditLimit {
+		return nil, errAuditLimit
+	}
+
+	records := []entity.AuditRecord{}
+	first := from.UTC().Truncate(24 * time.Hour)
+	for day := to.UTC().Truncate(24 * time.Hour); !day.Before(first) && len(records) < limit; day = day.Add(-24 * time.Hour) {
+		dayRecords, err := s.auditRepository.List(ctx, appID, day, from, to, key, limit-len(records))
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, dayRecords...)
+	}
+	return records, nil
+}
+
+// Previous returns the value of key in appID that a write is about to
+// replace, or nil when the key does not exist. Other read failures are
+// returned rather than treated as a missing key, so the write is refused
+// instead of misstating what it replaced.
+func Previous(ctx context.Context, keyValueService KeyValueService, appID, key string) (*entity.KeyValue, error) {
+	current, err := keyValueService.Get(ctx, appID, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+// PreviousHash returns the hash of previous, recorded as the old hash of the
+// write replacing it, or an empty string when there was no previous value.
+func PreviousHash(previous *entity.KeyValue) string {
+	if previous == nil {
+		return ""
+	}
+	return HashValue(previous.Value)
+}
+
+// HashValue returns the hex SHA-256 of a value, as recorded in audit
+// records.
+func HashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
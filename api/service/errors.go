@@ -0,0 +1,22 @@
+package service
+
+import "github.com/keanutaufan/kvstored/api/repository"
+
+// The service reports the same error kinds as the repository so callers only
+// need to depend on this package.
+var (
+	ErrNotFound    = repository.ErrNotFound
+	ErrConflict    = repository.ErrConflict
+	ErrValidation  = repository.ErrValidation
+	ErrUnavailable = repository.ErrUnavailable
+	ErrTimeout     = repository.ErrTimeout
+
+	ErrKeyExists       = repository.ErrKeyExists
+	ErrVersionMismatch = repository.ErrVersionMismatch
+)
+
+var (
+	errEmptyAppID = repository.NewError(ErrValidation, "app_id cannot be empty")
+	errEmptyKey   = repository.NewError(ErrValidation, "key cannot be empty")
+	errEmptyValue = repository.NewError(ErrValidation, "value cannot be empty")
+)
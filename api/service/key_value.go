@@ -2,7 +2,6 @@ package service
 
 import (
 	"context"
-	"errors"
 
 	"github.com/keanutaufan/kvstored/api/entity"
 	"github.com/keanutaufan/kvstored/api/repository"
@@ -14,29 +13,35 @@ type KeyValueService interface {
 	Get(ctx context.Context, appID, key string) (entity.KeyValue, error)
 	Update(ctx context.Context, keyValue entity.KeyValue) error
 	Delete(ctx context.Context, appID, key string) error
+	CompareAndSet(ctx context.Context, keyValue entity.KeyValue, version int64) error
+	CompareAndDelete(ctx context.Context, appID, key string, version int64) error
+	Create(ctx context.Context, keyValue entity.KeyValue) error
+	Stats(ctx context.Context, appID string) (entity.AppStats, error)
+	Policy(appID string) ValidationPolicy
 }
 
 type keyValueService struct {
 	kvRepository repository.KeyValueRepository
+	policies     ValidationPolicies
 }
 
-func NewKeyValueService(kvRepository repository.KeyValueRepository) *keyValueService {
-	return &keyValueService{kvRepository: kvRepository}
+func NewKeyValueService(kvRepository repository.KeyValueRepository, policies ValidationPolicies) *keyValueService {
+	return &keyValueService{
+		kvRepository: kvRepository,
+		policies:     policies,
+	}
 }
 
 func (s *keyValueService) GetAll(ctx context.Context, appID string) ([]entity.KeyValue, error) {
 	if appID == "" {
-		return nil, errors.New("app_id cannot be empty")
+		return nil, errEmptyAppID
 	}
 	return s.kvRepository.GetAll(ctx, appID)
 }
 
 func (s *keyValueService) Set(ctx context.Context, keyValue entity.KeyValue) error {
-	if keyValue.Key == "" {
-		return errors.New("key cannot be empty")
-	}
-	if keyValue.Value == "" {
-		return errors.New("value cannot be empty")
+	if err := s.validate(keyValue); err != nil {
+		return err
 	}
 	return s.kvRepository.Set(ctx, keyValue)
 }
@@ -50,11 +55,8 @@ func (s *keyValueService) Get(ctx context.Context, appID, key string) (entity.Ke
 }
 
 func (s *keyValueService) Update(ctx context.Context, keyValue entity.KeyValue) error {
-	if keyValue.Key == "" {
-		return errors.New("key cannot be empty")
-	}
-	if keyValue.Value == "" {
-		return errors.New("value cannot be empty")
+	if err := s.validate(keyValue); err != nil {
+		return err
 	}
 	return s.kvRepository.Update(ctx, keyValue)
 }
@@ -62,3 +64,45 @@ func (s *keyValueService) Update(ctx context.Context, keyValue entity.KeyValue)
 func (s *keyValueService) Delete(ctx context.Context, appID, key string) error {
 	return s.kvRepository.Delete(ctx, appID, key)
 }
+
+func (s *keyValueService) CompareAndSet(ctx context.Context, keyValue entity.KeyValue, version int64) error {
+	if err := s.validate(keyValue); err != nil {
+		return err
+	}
+	return s.kvRepository.CompareAndSet(ctx, keyValue, version)
+}
+
+func (s *keyValueService) CompareAndDelete(ctx context.Context, appID, key string, version int64) error {
+	return s.kvRepository.CompareAndDelete(ctx, appID, key, version)
+}
+
+func (s *keyValueService) Create(ctx context.Context, keyValue entity.KeyValue) error {
+	if err := s.validate(keyValue); err != nil {
+		return err
+	}
+	return s.kvRepository.Create(ctx, keyValue)
+}
+
+func (s *keyValueService) Stats(ctx context.Context, appID string) (entity.AppStats, error) {
+	if appID == "" {
+		return entity.AppStats{}, errEmptyAppID
+	}
+	return s.kvRepository.Stats(ctx, appID)
+}
+
+// Policy returns the validation policy writes to appID are checked against.
+func (s *keyValueService) Policy(appID string) ValidationPolicy {
+	return s.policies.For(appID)
+}
+
+// validate checks a key and value against the policy of their app before
+// they are written.
+func (s *keyValueService) validate(keyValue entity.KeyValue) error {
+	if keyValue.Key == "" {
+		return errEmptyKey
+	}
+	if keyValue.Value == "" {
+		return errEmptyValue
+	}
+	return s.policies.For(keyValue.AppID).Validate(keyValue)
+}
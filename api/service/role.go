@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/keanutaufan/kvstored/api/auth"
+	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/repository"
+)
+
+// roleCacheTTL bounds how long a node keeps using a cached role, and so how
+// long a change made on another node takes to apply here.
+const roleCacheTTL = 30 * time.Second
+
+var (
+	errInvalidRoleName    = repository.NewError(ErrValidation, "role name cannot be empty or contain whitespace")
+	errEmptyRolePolicies  = repository.NewError(ErrValidation, "policies cannot be empty")
+	errEmptyPolicyAppID   = repository.NewError(ErrValidation, "policy app_id cannot be empty")
+	errEmptyPolicyActions = repository.NewError(ErrValidation, "policy actions cannot be empty")
+)
+
+type RoleService interface {
+	auth.RoleResolver
+	// Put validates the role and creates it or replaces its policies.
+	Put(ctx context.Context, role entity.Role) (entity.Role, error)
+	Get(ctx context.Context, name string) (entity.Role, error)
+	List(ctx context.Context) ([]entity.Role, error)
+	Delete(ctx context.Context, name string) error
+}
+
+type roleService struct {
+	roleRepository repository.RoleRepository
+
+	cache map[string]cachedRole
+	mu    sync.Mutex
+}
+
+type cachedRole struct {
+	policies []auth.Policy // nil when the role does not exist
+	expires  time.Time
+}
+
+func NewRoleService(roleRepository repository.RoleRepository) *roleService {
+	return &roleService{
+		roleRepository: roleRepository,
+		cache:          make(map[string]cachedRole),
+	}
+}
+
+func (s *roleService) Put(ctx context.Context, role entity.Role) (entity.Role, error) {
+	if role.Name == "" || strings.ContainsFunc(role.Name, unicode.IsSpace) {
+		return entity.Role{}, errInvalidRoleName
+	}
+	if _, err := policyRules(role.Policies); err != nil {
+		return entity.Role{}, err
+	}
+
+	role.UpdatedAt = time.Now()
+	if err := s.roleRepository.Put(ctx, role); err != nil {
+		return entity.Role{}, err
+	}
+
+	s.evict(role.Name)
+	return role, nil
+}
+
+func (s *roleService) Get(ctx context.Context, name string) (entity.Role, error) {
+	return s.roleRepository.Get(ctx, name)
+}
+
+func (s *roleService) List(ctx context.Context) ([]entity.Role, error) {
+	return s.roleRepository.List(ctx)
+}
+
+func (s *roleService) Delete(ctx context.Context, name string) error {
+	if err := s.roleRepository.Delete(ctx, name); err != nil {
+		return err
+	}
+
+	s.evict(name)
+	return nil
+}
+
+func (s *roleService) RolePolicies(ctx context.Context, roles []string) ([]auth.Policy, error) {
+	var policies []auth.Policy
+	for _, name := range roles {
+		rolePolicies, err := s.lookup(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, rolePolicies...)
+	}
+	return policies, nil
+}
+
+func (s *roleService) lookup(ctx context.Context, name string) ([]auth.Policy, error) {
+	s.mu.Lock()
+	cached, ok := s.cache[name]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.policies, nil
+	}
+
+	var policies []auth.Policy
+	role, err := s.roleRepository.Get(ctx, name)
+	if err == nil {
+		// Stored roles were validated by Put.
+		policies, _ = policyRules(role.Policies)
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.cache[name] = cachedRole{policies: policies, expires: time.Now().Add(roleCacheTTL)}
+	s.mu.Unlock()
+	return policies, nil
+}
+
+func (s *roleService) evict(name string) {
+	s.mu.Lock()
+	delete(s.cache, name)
+	s.mu.Unlock()
+}
+
+// policyRules validates stored policies and converts them to the form
+// checked by auth.Principal.
+func policyRules(policies []entity.Policy) ([]auth.Policy, error) {
+	if len(policies) == 0 {
+		return nil, errEmptyRolePolicies
+	}
+
+	rules := make([]auth.Policy, 0, len(policies))
+	for _, policy := range policies {
+		if strings.TrimSpace(policy.AppID) == "" {
+			return nil, errEmptyPolicyAppID
+		}
+		if err := auth.ValidateKeyPattern(policy.Key); err != nil {
+			return nil, err
+		}
+		if len(policy.Actions) == 0 {
+			return nil, errEmptyPolicyActions
+		}
+
+		rule := auth.Policy{AppID: policy.AppID, Key: policy.Key}
+		for _, name := range policy.Actions {
+			action, err := auth.ParseAction(name)
+			if err != nil {
+				return nil, err
+			}
+			rule.Actions = append(rule.Actions, action)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
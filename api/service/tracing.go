@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+
+	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracedKeyValueService struct {
+	next KeyValueService
+}
+
+// TraceKeyValueService returns a KeyValueService recording a span for every
+// call to next, with the repository queries it makes as children.
+func TraceKeyValueService(next KeyValueService) KeyValueService {
+	return tracedKeyValueService{next: next}
+}
+
+func startSpan(ctx context.Context, method, appID, key string) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{attribute.String("kvstored.app_id", appID)}
+	if key != "" {
+		attributes = append(attributes, attribute.String("kvstored.key", key))
+	}
+	return tracing.Tracer.Start(ctx, "KeyValueService."+method, trace.WithAttributes(attributes...))
+}
+
+func (s tracedKeyValueService) GetAll(ctx context.Context, appID string) ([]entity.KeyValue, error) {
+	ctx, span := startSpan(ctx, "GetAll", appID, "")
+	keyValues, err := s.next.GetAll(ctx, appID)
+	tracing.End(span, err)
+	return keyValues, err
+}
+
+func (s tracedKeyValueService) Set(ctx context.Context, keyValue entity.KeyValue) error {
+	ctx, span := startSpan(ctx, "Set", keyValue.AppID, keyValue.Key)
+	err := s.next.Set(ctx, keyValue)
+	tracing.End(span, err)
+	return err
+}
+
+func (s tracedKeyValueService) Get(ctx context.Context, appID, key string) (entity.KeyValue, error) {
+	ctx, span := startSpan(ctx, "Get", appID, key)
+	keyValue, err := s.next.Get(ctx, appID, key)
+	tracing.End(span, err)
+	return keyValue, err
+}
+
+func (s tracedKeyValueService) Update(ctx context.Context, keyValue entity.KeyValue) error {
+	ctx, span := startSpan(ctx, "Update", keyValue.AppID, keyValue.Key)
+	err := s.next.Update(ctx, keyValue)
+	tracing.End(span, err)
+	return err
+}
+
+func (s tracedKeyValueService) Delete(ctx context.Context, appID, key string) error {
+	ctx, span := startSpan(ctx, "Delete", appID, key)
+	err := s.next.Delete(ctx, appID, key)
+	tracing.End(span, err)
+	return err
+}
+
+func (s tracedKeyValueService) CompareAndSet(ctx context.Context, keyValue entity.KeyValue, version int64) error {
+	ctx, span := startSpan(ctx, "CompareAndSet", keyValue.AppID, keyValue.Key)
+	err := s.next.CompareAndSet(ctx, keyValue, version)
+	tracing.End(span, err)
+	return err
+}
+
+func (s tracedKeyValueService) CompareAndDelete(ctx context.Context, appID, key string, version int64) error {
+	ctx, span := startSpan(ctx, "CompareAndDelete", appID, key)
+	err := s.next.CompareAndDelete(ctx, appID, key, version)
+	tracing.End(span, err)
+	return err
+}
+
+func (s tracedKeyValueService) Create(ctx context.Context, keyValue entity.KeyValue) error {
+	ctx, span := startSpan(ctx, "Create", keyValue.AppID, keyValue.Key)
+	err := s.next.Create(ctx, keyValue)
+	tracing.End(span, err)
+	return err
+}
+
+func (s tracedKeyValueService) Stats(ctx context.Context, appID string) (entity.AppStats, error) {
+	ctx, span := startSpan(ctx, "Stats", appID, "")
+	stats, err := s.next.Stats(ctx, appID)
+	tracing.End(span, err)
+	return stats, err
+}
+
+func (s tracedKeyValueService) Policy(appID string) ValidationPolicy {
+	return s.next.Policy(appID)
+}
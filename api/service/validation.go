@@ -0,0 +1,144 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/keanutaufan/kvstored/api/entity"
+	"github.com/keanutaufan/kvstored/api/repository"
+)
+
+// ValidationPolicy constrains the keys and values written to an app. Zero
+// limits and an empty KeyPattern impose no constraint.
+type ValidationPolicy struct {
+	MaxKeyLength     int      `json:"max_key_length"` // bytes
+	KeyPattern       Pattern  `json:"key_pattern"`
+	MaxValueSize     int      `json:"max_value_size"` // bytes
+	ReservedPrefixes []string `json:"reserved_prefixes"`
+}
+
+// Pattern is a regular expression read from and written as a JSON string.
+// The empty pattern matches every key.
+type Pattern struct {
+	*regexp.Regexp
+}
+
+func (p *Pattern) UnmarshalJSON(data []byte) error {
+	var expr string
+	if err := json.Unmarshal(data, &expr); err != nil {
+		return err
+	}
+	if expr == "" {
+		p.Regexp = nil
+		return nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	p.Regexp = re
+	return nil
+}
+
+func (p Pattern) MarshalJSON() ([]byte, error) {
+	if p.Regexp == nil {
+		return json.Marshal("")
+	}
+	return json.Marshal(p.String())
+}
+
+// DefaultValidationPolicy rejects keys with whitespace or control characters
+// and values too large for socket clients to handle comfortably.
+func DefaultValidationPolicy() ValidationPolicy {
+	return ValidationPolicy{
+		MaxKeyLength: 256,
+		KeyPattern:   Pattern{regexp.MustCompile(`^[^\s\p{Cc}]+$`)},
+		MaxValueSize: 256 * 1024,
+	}
+}
+
+// Validate checks a key and value about to be written.
+func (p ValidationPolicy) Validate(keyValue entity.KeyValue) error {
+	if p.MaxKeyLength > 0 && len(keyValue.Key) > p.MaxKeyLength {
+		return repository.NewError(ErrValidation, fmt.Sprintf("key cannot be longer than %d bytes", p.MaxKeyLength))
+	}
+	if p.KeyPattern.Regexp != nil && !p.KeyPattern.MatchString(keyValue.Key) {
+		return repository.NewError(ErrValidation, fmt.Sprintf("key %q does not match the pattern %s", keyValue.Key, p.KeyPattern))
+	}
+	for _, prefix := range p.ReservedPrefixes {
+		if strings.HasPrefix(keyValue.Key, prefix) {
+			return repository.NewError(ErrValidation, fmt.Sprintf("keys starting with %q are reserved", prefix))
+		}
+	}
+	if p.MaxValueSize > 0 && len(keyValue.Value) > p.MaxValueSize {
+		return repository.NewError(ErrValidation, fmt.Sprintf("value cannot be larger than %d bytes", p.MaxValueSize))
+	}
+	return nil
+}
+
+// ValidationPolicies holds the policy of every app: Default, with the
+// fields set in Apps overriding it per app.
+type ValidationPolicies struct {
+	Default ValidationPolicy
+	Apps    map[string]ValidationPolicy
+}
+
+func DefaultValidationPolicies() ValidationPolicies {
+	return ValidationPolicies{Default: DefaultValidationPolicy()}
+}
+
+// LoadValidationPolicies reads policies from a JSON file such as
+//
+//	{
+//		"default": {"max_value_size": 65536, "reserved_prefixes": ["_system/"]},
+//		"apps": {"billing": {"key_pattern": "^[a-z0-9/_-]+$"}}
+//	}
+//
+// Fields missing from "default" keep the built-in defaults, and fields
+// missing from an app keep the resulting default.
+func LoadValidationPolicies(path string) (ValidationPolicies, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ValidationPolicies{}, err
+	}
+
+	var file struct {
+		Default json.RawMessage            `json:"default"`
+		Apps    map[string]json.RawMessage `json:"apps"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return ValidationPolicies{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	policies := DefaultValidationPolicies()
+	if file.Default != nil {
+		if err := json.Unmarshal(file.Default, &policies.Default); err != nil {
+			return ValidationPolicies{}, fmt.Errorf("parsing default policy in %s: %w", path, err)
+		}
+	}
+
+	policies.Apps = make(map[string]ValidationPolicy, len(file.Apps))
+	for appID, raw := range file.Apps {
+		policy := policies.Default
+		// Decoding into the copy must not reuse the default's prefixes.
+		policy.ReservedPrefixes = slices.Clone(policy.ReservedPrefixes)
+		if err := json.Unmarshal(raw, &policy); err != nil {
+			return ValidationPolicies{}, fmt.Errorf("parsing policy of app %q in %s: %w", appID, path, err)
+		}
+		policies.Apps[appID] = policy
+	}
+	return policies, nil
+}
+
+// For returns the policy of appID.
+func (p ValidationPolicies) For(appID string) ValidationPolicy {
+	if policy, ok := p.Apps[appID]; ok {
+		return policy
+	}
+	return p.Default
+}
@@ -0,0 +1,70 @@
+// Package tracing exports OpenTelemetry traces and propagates trace context
+// between nodes.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const ServiceName = "kvstored"
+
+// Tracer starts the spans kvstored records itself. Until Setup is called,
+// its spans are not recorded.
+var Tracer = otel.Tracer("github.com/keanutaufan/kvstored/api")
+
+// Setup exports spans over OTLP/gRPC to the collector at endpoint, or the
+// one set by the standard OTEL_EXPORTER_OTLP_ENDPOINT variable when endpoint
+// is empty, localhost:4317 by default, and propagates trace context in W3C
+// traceparent headers. Sampling follows OTEL_TRACES_SAMPLER. The returned
+// function flushes buffered spans.
+func Setup(ctx context.Context, nodeID, endpoint string) (func(context.Context) error, error) {
+	var options []otlptracegrpc.Option
+	if endpoint != "" {
+		options = append(options, otlptracegrpc.WithEndpointURL(endpoint))
+	}
+	exporter, err := otlptracegrpc.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName(ServiceName),
+			semconv.ServiceInstanceID(nodeID),
+		),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return provider.Shutdown, nil
+}
+
+// End marks span as failed when err is not nil and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}